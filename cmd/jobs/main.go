@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/queue"
+)
+
+const usage = `Usage: jobs [flags] <command> [ids...]
+
+Commands:
+  stats            job counts per queue and state
+  list             recent jobs matching -queue/-kind/-state
+  show <id>        one job in full
+  requeue [ids]    make jobs runnable again with fresh attempts
+                   (no ids: every job matching the filters, dead by default)
+  purge            delete done jobs finished before -older-than
+
+Flags:
+`
+
+func main() {
+	var (
+		configPath  = flag.String("config", "", "Path to a YAML or TOML database config file")
+		queueName   = flag.String("queue", "", "Only this queue")
+		kind        = flag.String("kind", "", "Only this job kind")
+		state       = flag.String("state", "", "Only this state (pending, running, done, dead)")
+		limit       = flag.Int("limit", 50, "Maximum jobs to list")
+		olderThan   = flag.Duration("older-than", 7*24*time.Hour, "purge: finished at least this long ago")
+		includeDead = flag.Bool("dead", false, "purge: dead jobs too")
+	)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	cfg, err := database.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = "playground-jobs"
+	}
+	pool, err := database.NewPool(ctx, cfg)
+	if err != nil {
+		log.Fatal("Failed to connect:", err)
+	}
+	defer pool.Close()
+
+	filter := queue.Filter{Queue: *queueName, Kind: *kind, State: queue.State(*state), Limit: *limit}
+	ids, err := parseIDs(flag.Args()[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "stats":
+		err = printStats(ctx, pool)
+	case "list":
+		err = printList(ctx, pool, filter)
+	case "show":
+		if len(ids) != 1 {
+			log.Fatal("Usage: jobs show <id>")
+		}
+		err = printJob(ctx, pool, ids[0])
+	case "requeue":
+		var n int64
+		n, err = queue.Requeue(ctx, pool, ids, filter)
+		if err == nil {
+			fmt.Printf("Requeued %d jobs\n", n)
+		}
+	case "purge":
+		var n int64
+		n, err = queue.Purge(ctx, pool, *olderThan, *includeDead)
+		if err == nil {
+			fmt.Printf("Purged %d jobs\n", n)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseIDs(args []string) ([]int64, error) {
+	var ids []int64
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job id %q", arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func printStats(ctx context.Context, pool *database.Pool) error {
+	stats, err := queue.Stats(ctx, pool)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUEUE\tDUE\tSCHEDULED\tRUNNING\tDONE\tDEAD\tOLDEST DUE")
+	for _, s := range stats {
+		oldest := "-"
+		if s.OldestDue != nil {
+			oldest = time.Since(*s.OldestDue).Round(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", s.Queue, s.Pending, s.Scheduled, s.Running, s.Done, s.Dead, oldest)
+	}
+	return tw.Flush()
+}
+
+func printList(ctx context.Context, pool *database.Pool, filter queue.Filter) error {
+	jobs, err := queue.List(ctx, pool, filter)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tQUEUE\tKIND\tSTATE\tPRI\tATTEMPTS\tRUN AT\tLAST ERROR")
+	for _, j := range jobs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%d/%d\t%s\t%s\n",
+			j.ID, j.Queue, j.Kind, j.State, j.Priority, j.Attempts, j.MaxAttempts,
+			j.RunAt.Local().Format(time.DateTime), truncate(deref(j.LastError), 60))
+	}
+	return tw.Flush()
+}
+
+func printJob(ctx context.Context, pool *database.Pool, id int64) error {
+	j, err := queue.Get(ctx, pool, id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ID:           %d\n", j.ID)
+	fmt.Printf("Queue:        %s\n", j.Queue)
+	fmt.Printf("Kind:         %s\n", j.Kind)
+	fmt.Printf("State:        %s\n", j.State)
+	fmt.Printf("Priority:     %d\n", j.Priority)
+	fmt.Printf("Attempts:     %d/%d\n", j.Attempts, j.MaxAttempts)
+	fmt.Printf("Run at:       %s\n", j.RunAt.Local().Format(time.RFC3339))
+	fmt.Printf("Created at:   %s\n", j.CreatedAt.Local().Format(time.RFC3339))
+	if j.FinishedAt != nil {
+		fmt.Printf("Finished at:  %s\n", j.FinishedAt.Local().Format(time.RFC3339))
+	}
+	if j.LockedBy != nil {
+		fmt.Printf("Locked by:    %s until %s\n", *j.LockedBy, j.LockedUntil.Local().Format(time.RFC3339))
+	}
+	if j.LastError != nil {
+		fmt.Printf("Last error:   %s\n", *j.LastError)
+	}
+	fmt.Printf("Payload:      %s\n", j.Payload)
+	return nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/partman"
+)
+
+func main() {
+	var (
+		configPath = flag.String("config", "", "Path to a YAML or TOML database config file")
+		tablesPath = flag.String("tables", "partman.example.yaml", "Path to a YAML or TOML partman table config")
+		dryRun     = flag.Bool("dry-run", false, "Print planned changes without applying them")
+		interval   = flag.Duration("interval", 0, "Keep running, maintaining tables this often (0 runs once)")
+	)
+	flag.Parse()
+
+	tables, err := partman.LoadTables(*tablesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := database.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = "playground-partman"
+	}
+	pool, err := database.NewPool(ctx, cfg)
+	if err != nil {
+		log.Fatal("Failed to connect:", err)
+	}
+	defer pool.Close()
+
+	manager := partman.New(pool)
+
+	switch {
+	case *dryRun:
+		failed := false
+		for _, tc := range tables {
+			actions, err := manager.Plan(ctx, tc)
+			if err != nil {
+				log.Printf("%s: %v", tc.Table, err)
+				failed = true
+				continue
+			}
+			if len(actions) == 0 {
+				fmt.Printf("%s: up to date\n", tc.Table)
+			}
+			for _, action := range actions {
+				fmt.Printf("%s: would %s\n", tc.Table, action)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	case *interval > 0:
+		log.Printf("Maintaining %d tables every %s", len(tables), *interval)
+		if err := manager.Run(ctx, tables, *interval); err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+
+	default:
+		actions, err := manager.MaintainAll(ctx, tables)
+		for _, action := range actions {
+			fmt.Printf("%s: %s\n", action.Table, action)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(actions) == 0 {
+			fmt.Println("All tables up to date")
+		}
+	}
+}
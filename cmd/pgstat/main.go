@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/pgstat"
+)
+
+const usage = `Usage: pgstat [flags] <command> [args...]
+
+Commands:
+  top                   top statements from pg_stat_statements
+  snapshot [label]      save pg_stat_statements to stat_snapshots
+  snapshots             list saved snapshots
+  diff <from> [to]      top statements by what changed between two snapshots
+                        (no to: between the snapshot and now)
+  drop <id>             delete a snapshot
+  reset                 clear pg_stat_statements
+
+Flags:
+`
+
+func main() {
+	var (
+		configPath = flag.String("config", "", "Path to a YAML or TOML database config file")
+		by         = flag.String("by", string(pgstat.ByTotalTime), "Rank by total, mean, calls, rows, hit or temp")
+		limit      = flag.Int("limit", 20, "Number of statements to show")
+		format     = flag.String("format", "table", "Output format: table, json or csv")
+		width      = flag.Int("width", 80, "table: truncate queries to this many characters")
+	)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	order := pgstat.Order(*by)
+	if !slices.Contains(pgstat.Orders, order) {
+		log.Fatalf("Unknown -by %q", *by)
+	}
+	if *format != "table" && *format != "json" && *format != "csv" {
+		log.Fatalf("Unknown -format %q", *format)
+	}
+
+	ctx := context.Background()
+	cfg, err := database.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = "playground-pgstat"
+	}
+	pool, err := database.NewPool(ctx, cfg)
+	if err != nil {
+		log.Fatal("Failed to connect:", err)
+	}
+	defer pool.Close()
+
+	args := flag.Args()[1:]
+
+	switch flag.Arg(0) {
+	case "top":
+		var stmts []pgstat.Statement
+		stmts, err = pgstat.Top(ctx, pool, order, *limit)
+		if err == nil {
+			err = printStatements(os.Stdout, stmts, *format, *width)
+		}
+	case "snapshot":
+		var snap pgstat.Snapshot
+		snap, err = pgstat.Take(ctx, pool, strings.Join(args, " "))
+		if err == nil {
+			fmt.Printf("Snapshot %d: %d statements\n", snap.ID, snap.Statements)
+		}
+	case "snapshots":
+		var snaps []pgstat.Snapshot
+		snaps, err = pgstat.Snapshots(ctx, pool)
+		if err == nil {
+			err = printSnapshots(os.Stdout, snaps, *format)
+		}
+	case "diff":
+		ids, perr := parseIDs(args)
+		if perr != nil || len(ids) < 1 || len(ids) > 2 {
+			log.Fatal("Usage: pgstat diff <from> [to]")
+		}
+		var to int64
+		if len(ids) == 2 {
+			to = ids[1]
+		}
+		var stmts []pgstat.Statement
+		stmts, err = pgstat.Diff(ctx, pool, ids[0], to, order, *limit)
+		if err == nil {
+			err = printStatements(os.Stdout, stmts, *format, *width)
+		}
+	case "drop":
+		ids, perr := parseIDs(args)
+		if perr != nil || len(ids) != 1 {
+			log.Fatal("Usage: pgstat drop <id>")
+		}
+		err = pgstat.Drop(ctx, pool, ids[0])
+		if err == nil {
+			fmt.Printf("Dropped snapshot %d\n", ids[0])
+		}
+	case "reset":
+		err = pgstat.Reset(ctx, pool)
+		if err == nil {
+			fmt.Println("Reset pg_stat_statements")
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseIDs(args []string) ([]int64, error) {
+	var ids []int64
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot id %q", arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func printStatements(out io.Writer, stmts []pgstat.Statement, format string, width int) error {
+	switch format {
+	case "json":
+		return printJSON(out, stmts)
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"queryid", "calls", "total_time_ms", "mean_time_ms", "rows",
+			"shared_blks_hit", "shared_blks_read", "hit_ratio", "temp_blks_read", "temp_blks_written", "query"})
+		for _, s := range stmts {
+			w.Write([]string{
+				strconv.FormatInt(s.QueryID, 10), strconv.FormatInt(s.Calls, 10),
+				strconv.FormatFloat(s.TotalTime, 'f', 3, 64), strconv.FormatFloat(s.MeanTime, 'f', 3, 64),
+				strconv.FormatInt(s.Rows, 10), strconv.FormatInt(s.SharedHit, 10), strconv.FormatInt(s.SharedRead, 10),
+				strconv.FormatFloat(s.HitRatio, 'f', 4, 64), strconv.FormatInt(s.TempRead, 10),
+				strconv.FormatInt(s.TempWritten, 10), s.Query,
+			})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "CALLS\tTOTAL\tMEAN\tROWS\tHIT %\tTEMP BLKS\t QUERY")
+	for _, s := range stmts {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%.1f\t%d\t %s\n",
+			s.Calls, ms(s.TotalTime), ms(s.MeanTime), s.Rows, 100*s.HitRatio,
+			s.TempRead+s.TempWritten, truncate(oneLine(s.Query), width))
+	}
+	return tw.Flush()
+}
+
+func printSnapshots(out io.Writer, snaps []pgstat.Snapshot, format string) error {
+	switch format {
+	case "json":
+		return printJSON(out, snaps)
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"id", "label", "taken_at", "statements"})
+		for _, s := range snaps {
+			w.Write([]string{strconv.FormatInt(s.ID, 10), s.Label, s.TakenAt.Format(time.RFC3339), strconv.FormatInt(s.Statements, 10)})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTAKEN AT\tSTATEMENTS\tLABEL")
+	for _, s := range snaps {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", s.ID, s.TakenAt.Local().Format(time.DateTime), s.Statements, s.Label)
+	}
+	return tw.Flush()
+}
+
+func printJSON(out io.Writer, v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// ms formats milliseconds as a duration, e.g. 1.234s
+func ms(v float64) string {
+	d := time.Duration(v * float64(time.Millisecond))
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if n <= 0 || len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"roguh.com/postgres_playground/pkg/pgstat"
+)
+
+var testStatements = []pgstat.Statement{
+	{QueryID: 42, Query: "SELECT *\n  FROM assets\n  WHERE site_id = $1", Calls: 1500, TotalTime: 2345.6,
+		MeanTime: 1.5637, Rows: 9000, SharedHit: 300, SharedRead: 100, HitRatio: 0.75, TempRead: 2, TempWritten: 3},
+	{QueryID: -7, Query: "UPDATE sites SET name = $1", Calls: 1, TotalTime: 0.25, MeanTime: 0.25, HitRatio: 1},
+}
+
+func TestPrintStatementsTable(t *testing.T) {
+	var b strings.Builder
+	if err := printStatements(&b, testStatements, "table", 30); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("want a header and 2 rows, got:\n%s", b.String())
+	}
+	for _, want := range []string{"1500", "2.346s", "1.56ms", "75.0", " SELECT * FROM assets WHERE sit..."} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("row %q missing %q", lines[1], want)
+		}
+	}
+	if !strings.Contains(lines[2], "250µs") || !strings.Contains(lines[2], "100.0") {
+		t.Errorf("row %q: want 250µs and a 100%% hit ratio", lines[2])
+	}
+}
+
+func TestPrintStatementsJSON(t *testing.T) {
+	var b strings.Builder
+	if err := printStatements(&b, testStatements, "json", 30); err != nil {
+		t.Fatal(err)
+	}
+	var got []pgstat.Statement
+	if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != testStatements[0] {
+		t.Errorf("JSON round trip: got %+v", got)
+	}
+	if !strings.Contains(b.String(), `"total_time_ms": 2345.6`) {
+		t.Errorf("JSON should use the snake_case field names:\n%s", b.String())
+	}
+}
+
+func TestPrintStatementsCSV(t *testing.T) {
+	var b strings.Builder
+	if err := printStatements(&b, testStatements, "csv", 30); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(b.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0][0] != "queryid" {
+		t.Fatalf("want a header and 2 rows, got %v", records)
+	}
+	row := records[1]
+	if row[0] != "42" || row[1] != "1500" || row[2] != "2345.600" || row[7] != "0.7500" {
+		t.Errorf("row = %v", row)
+	}
+	// CSV keeps the full query, newlines and all
+	if row[len(row)-1] != testStatements[0].Query {
+		t.Errorf("query = %q, want it untruncated", row[len(row)-1])
+	}
+}
+
+func TestPrintSnapshots(t *testing.T) {
+	taken := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	snaps := []pgstat.Snapshot{{ID: 3, Label: "before deploy", TakenAt: taken, Statements: 120}}
+
+	var b strings.Builder
+	if err := printSnapshots(&b, snaps, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != "id,label,taken_at,statements\n3,before deploy,2026-10-01T12:00:00Z,120\n" {
+		t.Errorf("csv = %q", got)
+	}
+
+	b.Reset()
+	if err := printSnapshots(&b, snaps, "table"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "before deploy") || !strings.HasPrefix(b.String(), "ID") {
+		t.Errorf("table = %q", b.String())
+	}
+}
+
+func TestTruncateKeepsRunesWhole(t *testing.T) {
+	got := truncate("SELECT 'größe'", 11)
+	if got != "SELECT 'grö..." {
+		t.Errorf("truncate = %q", got)
+	}
+	if !utf8.ValidString(truncate("SELECT 'größe'", 10)) {
+		t.Error("truncated in the middle of a character")
+	}
+}
@@ -3,13 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"strings"
 	"time"
 
-	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/bulk"
 	"roguh.com/postgres_playground/pkg/database"
 )
 
@@ -229,7 +231,25 @@ func seedSites(ctx context.Context, pool *database.Pool, count int) error {
 		"BR": {"São Paulo", "Rio de Janeiro", "Brasília", "Salvador", "Fortaleza"},
 	}
 
-	batch := &pgx.Batch{}
+	inserted := 0
+	batch := &bulk.Batch{}
+	flush := func() error {
+		results, err := batch.Exec(ctx, pool, bulk.BatchConfig{RetryIndividually: true})
+		for _, r := range results {
+			if r.Err == nil {
+				inserted++
+			} else {
+				log.Printf("  site insert %d failed: %v", r.Index, r.Err)
+			}
+		}
+		batch = &bulk.Batch{}
+		var batchErr *bulk.BatchError
+		if errors.As(err, &batchErr) && batchErr.Failed < batchErr.Total {
+			return nil // bad rows are logged above; keep the rest
+		}
+		return err
+	}
+
 	for i := 0; i < count; i++ {
 		country := countries[rand.Intn(len(countries))]
 		city := cities[country][rand.Intn(len(cities[country]))]
@@ -242,11 +262,10 @@ func seedSites(ctx context.Context, pool *database.Pool, count int) error {
 			lat, lon = &latVal, &lonVal
 		}
 
-		query := `
+		batch.Queue(`
 			INSERT INTO sites (name, address, city, country, coordinates, metadata)
 			VALUES ($1, $2, $3, $4, point($5, $6), $7)
-		`
-		batch.Queue(query,
+		`,
 			fmt.Sprintf("%s Site %d", city, i+1),
 			fmt.Sprintf("%d %s Street", rand.Intn(9999)+1, randomFrom("Main", "First", "Park", "Oak", "Elm")),
 			city,
@@ -256,26 +275,38 @@ func seedSites(ctx context.Context, pool *database.Pool, count int) error {
 
 		// Execute in batches
 		if batch.Len() >= 100 {
-			br := pool.SendBatch(ctx, batch)
-			if err := br.Close(); err != nil {
+			if err := flush(); err != nil {
 				return fmt.Errorf("batch insert sites: %w", err)
 			}
-			batch = &pgx.Batch{}
 		}
 	}
 
 	// Final batch
 	if batch.Len() > 0 {
-		br := pool.SendBatch(ctx, batch)
-		if err := br.Close(); err != nil {
+		if err := flush(); err != nil {
 			return fmt.Errorf("final batch insert sites: %w", err)
 		}
 	}
 
-	log.Printf("✓ Seeded %d sites", count)
+	log.Printf("✓ Seeded %d sites", inserted)
 	return nil
 }
 
+// assetSeed is one generated asset, loaded with COPY
+type assetSeed struct {
+	SiteID          string          `db:"site_id"`
+	MacAddress      string          `db:"mac_address"`
+	SerialNumber    string          `db:"serial_number"`
+	AssetType       string          `db:"asset_type"`
+	Manufacturer    string          `db:"manufacturer"`
+	Model           string          `db:"model"`
+	FirmwareVersion string          `db:"firmware_version"`
+	Status          string          `db:"status"`
+	Config          json.RawMessage `db:"config"`
+	Telemetry       json.RawMessage `db:"telemetry"`
+	LastSeen        time.Time       `db:"last_seen"`
+}
+
 func seedAssets(ctx context.Context, pool *database.Pool, count int) error {
 	log.Printf("Seeding %d assets...", count)
 
@@ -303,57 +334,53 @@ func seedAssets(ctx context.Context, pool *database.Pool, count int) error {
 	manufacturers := []string{"Cisco", "Dell", "HP", "Ubiquiti", "APC", "Panduit", "Honeywell"}
 	statuses := []string{"active", "active", "active", "active", "maintenance", "offline", "retired"}
 
-	batch := &pgx.Batch{}
-	for i := 0; i < count; i++ {
-		assetType := assetTypes[rand.Intn(len(assetTypes))]
-		manufacturer := manufacturers[rand.Intn(len(manufacturers))]
-
-		// Vary last_seen to simulate real-world scenarios
-		lastSeen := time.Now()
-		if rand.Float32() > 0.8 {
-			lastSeen = lastSeen.Add(-time.Duration(rand.Intn(72)) * time.Hour)
-		}
-
-		query := `
-			INSERT INTO assets (
-				site_id, mac_address, serial_number, asset_type,
-				manufacturer, model, firmware_version, status,
-				config, telemetry, last_seen
-			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-			ON CONFLICT (serial_number) DO NOTHING
-		`
-		batch.Queue(query,
-			siteIDs[rand.Intn(len(siteIDs))],
-			randomMAC(),
-			fmt.Sprintf("%s%d%s", manufacturer, time.Now().Unix(), rand.Intn(99999)),
-			assetType,
-			manufacturer,
-			fmt.Sprintf("%s-%d", assetType, rand.Intn(9999)),
-			fmt.Sprintf("%d.%d.%d", rand.Intn(5)+1, rand.Intn(20), rand.Intn(100)),
-			statuses[rand.Intn(len(statuses))],
-			genAssetConfig(),
-			genAssetTelemetry(),
-			lastSeen)
+	// Stream rows into a staging table with COPY, then insert them in one
+	// statement; duplicate serial numbers are skipped, not fatal
+	assets := func(yield func(assetSeed) bool) {
+		for i := 0; i < count; i++ {
+			assetType := assetTypes[rand.Intn(len(assetTypes))]
+			manufacturer := manufacturers[rand.Intn(len(manufacturers))]
+
+			// Vary last_seen to simulate real-world scenarios
+			lastSeen := time.Now()
+			if rand.Float32() > 0.8 {
+				lastSeen = lastSeen.Add(-time.Duration(rand.Intn(72)) * time.Hour)
+			}
 
-		// Execute in batches
-		if batch.Len() >= 100 {
-			br := pool.SendBatch(ctx, batch)
-			if err := br.Close(); err != nil {
-				return fmt.Errorf("batch insert assets: %w", err)
+			ok := yield(assetSeed{
+				SiteID:          siteIDs[rand.Intn(len(siteIDs))],
+				MacAddress:      randomMAC(),
+				SerialNumber:    fmt.Sprintf("%s%d%05d", manufacturer, time.Now().Unix(), rand.Intn(99999)),
+				AssetType:       assetType,
+				Manufacturer:    manufacturer,
+				Model:           fmt.Sprintf("%s-%d", assetType, rand.Intn(9999)),
+				FirmwareVersion: fmt.Sprintf("%d.%d.%d", rand.Intn(5)+1, rand.Intn(20), rand.Intn(100)),
+				Status:          statuses[rand.Intn(len(statuses))],
+				Config:          genAssetConfig(),
+				Telemetry:       genAssetTelemetry(),
+				LastSeen:        lastSeen,
+			})
+			if !ok {
+				return
 			}
-			batch = &pgx.Batch{}
 		}
 	}
 
-	// Final batch
-	if batch.Len() > 0 {
-		br := pool.SendBatch(ctx, batch)
-		if err := br.Close(); err != nil {
-			return fmt.Errorf("final batch insert assets: %w", err)
-		}
+	result, err := bulk.Upsert(ctx, pool, "assets", assets, bulk.UpsertConfig{
+		ConflictColumns: []string{"serial_number"},
+		DoNothing:       true,
+		Load: bulk.Config{
+			ChunkSize: 10000,
+			Progress: func(p bulk.Progress) {
+				log.Printf("  staged %d/%d assets (%.0f rows/sec)", p.Total, count, p.RowsPerSec())
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("upsert assets: %w", err)
 	}
 
-	log.Printf("✓ Seeded %d assets", count)
+	log.Printf("✓ Seeded %d assets (%d duplicates skipped) in %v", result.Inserted, result.Skipped, result.Elapsed.Round(time.Millisecond))
 	return nil
 }
 
@@ -394,12 +421,24 @@ func randomMAC() string {
 }
 
 func main() {
+	configPath := flag.String("config", "", "Path to a YAML or TOML database config file")
+	flag.Parse()
+
 	rand.Seed(time.Now().UnixNano())
 
 	ctx := context.Background()
 
 	// Connect to database
-	pool, err := database.NewPool(ctx, database.DefaultConfig())
+	cfg, err := database.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = "playground-seed"
+	}
+	// A single 100k-row upsert runs well past any app-sized timeout
+	cfg.DisableTimeouts()
+	pool, err := database.NewPool(ctx, cfg)
 	if err != nil {
 		log.Fatal("Failed to create pool:", err)
 	}
@@ -13,7 +13,11 @@ func main() {
 	ctx := context.Background()
 
 	// Connect to PostgreSQL
-	pool, err := database.NewPool(ctx, database.DefaultConfig())
+	cfg, err := database.LoadConfig("")
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	pool, err := database.NewPool(ctx, cfg)
 	if err != nil {
 		log.Fatal("Failed to connect:", err)
 	}
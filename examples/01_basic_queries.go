@@ -11,7 +11,11 @@ import (
 
 func main() {
 	ctx := context.Background()
-	pool, err := database.NewPool(ctx, database.DefaultConfig())
+	cfg, err := database.LoadConfig("")
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	pool, err := database.NewPool(ctx, cfg)
 	if err != nil {
 		log.Fatal("Failed to create pool:", err)
 	}
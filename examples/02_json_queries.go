@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"log"
 
-	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/backfill"
 	"roguh.com/postgres_playground/pkg/database"
 )
 
 func main() {
 	ctx := context.Background()
-	pool, err := database.NewPool(ctx, database.DefaultConfig())
+	cfg, err := database.LoadConfig("")
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	pool, err := database.NewPool(ctx, cfg)
 	if err != nil {
 		log.Fatal("Failed to create pool:", err)
 	}
@@ -326,6 +330,7 @@ func jsonIndexing(ctx context.Context, pool *database.Pool) {
 	fmt.Println("\n✓ Practical JSON query examples:")
 
 	// Find assets with specific config patterns
+	var features json.RawMessage
 	err = pool.QueryRow(ctx, `
 		WITH feature_stats AS (
 			SELECT
@@ -338,10 +343,10 @@ func jsonIndexing(ctx context.Context, pool *database.Pool) {
 		)
 		SELECT jsonb_object_agg(feature, enabled_count)
 		FROM feature_stats
-	`).Scan(&result)
+	`).Scan(&features)
 
 	if err == nil {
-		fmt.Printf("  - Enabled features across fleet: %s\n", string(result))
+		fmt.Printf("  - Enabled features across fleet: %s\n", string(features))
 	}
 
 	// Complex filtering with JSON
@@ -379,28 +384,35 @@ func jsonIndexing(ctx context.Context, pool *database.Pool) {
 			serial, assetType, powerMode, cpu)
 	}
 
-	// Transaction safety with JSON updates
-	fmt.Println("\n✓ Safe JSON updates in transaction:")
-	err = database.WithTx(ctx, pool, func(tx pgx.Tx) error {
-		// Update JSON atomically
-		_, err := tx.Exec(ctx, `
-			UPDATE assets
-			SET
-				config = jsonb_set(
-					config,
-					'{settings,auto_update}',
-					'true'
-				)
-			WHERE
-				asset_type = 'router'
-				AND config->'settings' IS NOT NULL
-				AND NOT (config->'settings' ? 'auto_update')
-			LIMIT 10
-		`)
-		return err
+	// Large JSON updates, a batch at a time: each batch of routers is its
+	// own short transaction, and progress is checkpointed so a rerun resumes
+	fmt.Println("\n✓ Chunked JSON backfill:")
+	runner, err := backfill.New(pool, backfill.Job{
+		Name:  "enable-router-auto-update",
+		Table: "assets",
+		Where: `asset_type = 'router'
+			AND config->'settings' IS NOT NULL
+			AND NOT (config->'settings' ? 'auto_update')`,
+		Set:           `config = jsonb_set(config, '{settings,auto_update}', 'true')`,
+		BatchSize:     500,
+		MaxRowsPerSec: 5000,
+		Progress: func(p backfill.Progress) {
+			fmt.Printf("  - batch %d: %d routers so far\n", p.Batches, p.Rows)
+		},
 	})
+	if err != nil {
+		log.Printf("Backfill error: %v", err)
+		return
+	}
+	result, err := runner.Run(ctx)
+	if err != nil {
+		log.Printf("Backfill error: %v", err)
+		return
+	}
+	fmt.Printf("  - Enabled auto_update for %d routers in %v\n", result.Rows, result.Elapsed)
 
-	if err == nil {
-		fmt.Println("  - Successfully enabled auto_update for routers")
+	// Forget the checkpoint so the demo can run again
+	if err := backfill.Reset(ctx, pool, "enable-router-auto-update"); err != nil {
+		log.Printf("Backfill reset error: %v", err)
 	}
 }
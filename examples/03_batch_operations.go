@@ -8,12 +8,17 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/bulk"
 	"roguh.com/postgres_playground/pkg/database"
 )
 
 func main() {
 	ctx := context.Background()
-	pool, err := database.NewPool(ctx, database.DefaultConfig())
+	cfg, err := database.LoadConfig("")
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	pool, err := database.NewPool(ctx, cfg)
 	if err != nil {
 		log.Fatal("Failed to create pool:", err)
 	}
@@ -67,11 +72,17 @@ func batchInserts(ctx context.Context, pool *database.Pool) {
 
 	fmt.Printf("✓ Inserted %d sites in %v (multi-value)\n", count, time.Since(start))
 
-	// Method 2: pgx.Batch (more flexible, supports different queries)
+	// Method 2: bulk.Batch (more flexible, supports different queries) with
+	// a result per statement. Every 25th row reuses a serial number, and
+	// those failures are retried on their own instead of sinking the batch.
 	start = time.Now()
-	batch := &pgx.Batch{}
+	batch := &bulk.Batch{}
 
 	for i := 0; i < 100; i++ {
+		serial := fmt.Sprintf("BATCH%d", time.Now().Unix()+int64(i))
+		if i > 0 && i%25 == 0 {
+			serial = fmt.Sprintf("BATCH%d", time.Now().Unix())
+		}
 		batch.Queue(`
 			INSERT INTO assets (
 				site_id, mac_address, serial_number, asset_type,
@@ -79,30 +90,40 @@ func batchInserts(ctx context.Context, pool *database.Pool) {
 			) VALUES (
 				(SELECT id FROM sites ORDER BY RANDOM() LIMIT 1),
 				$1, $2, $3, $4, $5, $6
-			)`,
+			)
+			RETURNING id`,
 			fmt.Sprintf("00:00:00:00:%02x:%02x", i/256, i%256),
-			fmt.Sprintf("BATCH%d", time.Now().Unix()+int64(i)),
+			serial,
 			"sensor",
 			"BatchCorp",
 			"Model-X",
 			"active")
 	}
 
-	br := pool.SendBatch(ctx, batch)
-	defer br.Close()
-
+	results, err := batch.Exec(ctx, pool, bulk.BatchConfig{RetryIndividually: true})
 	insertedCount := 0
-	for i := 0; i < batch.Len(); i++ {
-		_, err := br.Exec()
-		if err == nil {
+	for _, r := range results {
+		switch {
+		case r.Err == nil:
 			insertedCount++
+		case r.PgErr != nil:
+			fmt.Printf("  - item %d: %s (%s)\n", r.Index, r.PgErr.Message, r.PgErr.Code)
+		default:
+			fmt.Printf("  - item %d: %v\n", r.Index, r.Err)
 		}
 	}
+	if err != nil {
+		log.Printf("Batch: %v", err)
+	}
 
-	fmt.Printf("✓ Inserted %d assets in %v (pgx.Batch)\n",
+	fmt.Printf("✓ Inserted %d assets in %v (bulk.Batch)\n",
 		insertedCount, time.Since(start))
 
 	// Method 3: Prepared statement reuse
+	if err := pool.RequireSession("prepared statements"); err != nil {
+		log.Printf("Skipping prepared statements: %v", err)
+		return
+	}
 	start = time.Now()
 
 	// Transaction for prepared statement
@@ -145,11 +166,14 @@ func batchInserts(ctx context.Context, pool *database.Pool) {
 func batchUpdates(ctx context.Context, pool *database.Pool) {
 	fmt.Println("\n=== Batch Updates ===")
 
-	// Method 1: UPDATE with unnest() for bulk updates
+	// Method 1: UPDATE with unnest() for bulk updates. bulk.Update builds
+	// the statement, one array per column cast to the column's type
 	start := time.Now()
 
-	ids := make([]string, 20)
-	statuses := make([]string, 20)
+	type statusChange struct {
+		ID     string `db:"id"`
+		Status string `db:"status"`
+	}
 
 	// Get some asset IDs
 	rows, err := pool.Query(ctx,
@@ -158,60 +182,50 @@ func batchUpdates(ctx context.Context, pool *database.Pool) {
 		log.Printf("Query error: %v", err)
 		return
 	}
-
-	i := 0
-	for rows.Next() && i < 20 {
-		rows.Scan(&ids[i])
-		statuses[i] = "maintenance"
-		i++
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Printf("Query error: %v", err)
+		return
 	}
-	rows.Close()
 
-	result, err := pool.Exec(ctx, `
-		UPDATE assets a
-		SET status = u.status,
-		    updated_at = NOW()
-		FROM (
-			SELECT unnest($1::uuid[]) as id,
-			       unnest($2::text[]) as status
-		) u
-		WHERE a.id = u.id
-	`, ids[:i], statuses[:i])
+	changes := make([]statusChange, len(ids))
+	for i, id := range ids {
+		changes[i] = statusChange{ID: id, Status: "maintenance"}
+	}
 
+	updated, err := bulk.Update(ctx, pool, "assets", changes, bulk.UpdateConfig{
+		Key:   "id",
+		Extra: "updated_at = NOW()",
+	})
 	if err == nil {
 		fmt.Printf("✓ Bulk updated %d assets in %v (unnest)\n",
-			result.RowsAffected(), time.Since(start))
+			updated.Updated, time.Since(start))
 	}
 
-	// Method 2: UPDATE with CASE for different values
+	// Method 2: different values per row, merged into existing JSON instead
+	// of a hand-built CASE. Keys that match nothing are reported back.
 	start = time.Now()
 
-	caseQuery := `
-		UPDATE assets
-		SET telemetry = telemetry ||
-			CASE serial_number
-	`
-
-	args := []interface{}{}
-	whereClause := []string{}
-
-	for j := 0; j < 10; j++ {
-		serial := fmt.Sprintf("BATCH%d", time.Now().Unix()-int64(j*10))
-		caseQuery += fmt.Sprintf(`
-				WHEN $%d THEN $%d::jsonb`, len(args)+1, len(args)+2)
-		args = append(args, serial,
-			fmt.Sprintf(`{"case_update": %d, "metric": %d}`, j, j*10))
-		whereClause = append(args, fmt.Sprintf("$%d", len(args)))
+	type telemetryPatch struct {
+		SerialNumber string `db:"serial_number"`
+		Telemetry    string `db:"telemetry"`
 	}
 
-	caseQuery += `
-			END
-		WHERE serial_number IN (` + strings.Join(whereClause[len(whereClause)-10:], ",") + ")"
+	patches := make([]telemetryPatch, 10)
+	for j := range patches {
+		patches[j] = telemetryPatch{
+			SerialNumber: fmt.Sprintf("BATCH%d", time.Now().Unix()-int64(j*10)),
+			Telemetry:    fmt.Sprintf(`{"case_update": %d, "metric": %d}`, j, j*10),
+		}
+	}
 
-	result, err = pool.Exec(ctx, caseQuery, args...)
+	updated, err = bulk.Update(ctx, pool, "assets", patches, bulk.UpdateConfig{
+		Key: "serial_number",
+		Set: map[string]string{"telemetry": "t.telemetry || u.telemetry"},
+	})
 	if err == nil {
-		fmt.Printf("✓ Case-updated %d assets in %v\n",
-			result.RowsAffected(), time.Since(start))
+		fmt.Printf("✓ Patched %d assets in %v (%d serial numbers not found)\n",
+			updated.Updated, time.Since(start), len(updated.NotFound))
 	}
 
 	// Method 3: Temporary table for complex updates
@@ -265,19 +279,17 @@ func batchUpdates(ctx context.Context, pool *database.Pool) {
 func copyFromDemo(ctx context.Context, pool *database.Pool) {
 	fmt.Println("\n=== COPY FROM (Fastest Bulk Insert) ===")
 
-	start := time.Now()
-
-	// Prepare data
+	// Columns come from the db tags; no hand-kept column list
 	type assetRow struct {
-		siteID       string
-		macAddress   string
-		serialNumber string
-		assetType    string
-		manufacturer string
-		model        string
-		status       string
-		config       string
-		telemetry    string
+		SiteID       string `db:"site_id"`
+		MacAddress   string `db:"mac_address"`
+		SerialNumber string `db:"serial_number"`
+		AssetType    string `db:"asset_type"`
+		Manufacturer string `db:"manufacturer"`
+		Model        string `db:"model"`
+		Status       string `db:"status"`
+		Config       string `db:"config"`
+		Telemetry    string `db:"telemetry"`
 	}
 
 	// Get a site ID
@@ -288,45 +300,58 @@ func copyFromDemo(ctx context.Context, pool *database.Pool) {
 	rows := make([]assetRow, 10000)
 	for i := range rows {
 		rows[i] = assetRow{
-			siteID:       siteID,
-			macAddress:   fmt.Sprintf("AA:BB:CC:%02X:%02X:%02X", i/65536, (i/256)%256, i%256),
-			serialNumber: fmt.Sprintf("COPY%d%d", time.Now().Unix(), i),
-			assetType:    "sensor",
-			manufacturer: "CopyTest",
-			model:        "CT-1000",
-			status:       "active",
-			config:       `{"copy_test": true}`,
-			telemetry:    fmt.Sprintf(`{"batch": %d}`, i/1000),
+			SiteID:       siteID,
+			MacAddress:   fmt.Sprintf("AA:BB:CC:%02X:%02X:%02X", i/65536, (i/256)%256, i%256),
+			SerialNumber: fmt.Sprintf("COPY%d%d", time.Now().Unix(), i),
+			AssetType:    "sensor",
+			Manufacturer: "CopyTest",
+			Model:        "CT-1000",
+			Status:       "active",
+			Config:       `{"copy_test": true}`,
+			Telemetry:    fmt.Sprintf(`{"batch": %d}`, i/1000),
 		}
 	}
 
-	// Use CopyFrom
-	copyCount, err := pool.CopyFrom(
-		ctx,
-		pgx.Identifier{"assets"},
-		[]string{
-			"site_id", "mac_address", "serial_number", "asset_type",
-			"manufacturer", "model", "status", "config", "telemetry",
+	// COPY in chunks of 2500, two connections at a time
+	loader, err := bulk.NewLoader[assetRow](pool, "assets", bulk.Config{
+		ChunkSize:   2500,
+		Parallelism: 2,
+		Progress: func(p bulk.Progress) {
+			fmt.Printf("  - chunk %d: %d rows in %v (%d total, %.0f rows/sec)\n",
+				p.Chunk, p.Rows, p.Duration.Round(time.Millisecond), p.Total, p.RowsPerSec())
 		},
-		pgx.CopyFromSlice(len(rows), func(i int) ([]interface{}, error) {
-			r := rows[i]
-			return []interface{}{
-				r.siteID, r.macAddress, r.serialNumber, r.assetType,
-				r.manufacturer, r.model, r.status, r.config, r.telemetry,
-			}, nil
-		}),
-	)
-
+	})
+	if err != nil {
+		log.Printf("Loader error: %v", err)
+		return
+	}
+	result, err := loader.Load(ctx, rows)
 	if err != nil {
 		log.Printf("CopyFrom error: %v", err)
 	} else {
 		fmt.Printf("✓ Inserted %d assets in %v (%.0f rows/sec)\n",
-			copyCount, time.Since(start),
-			float64(copyCount)/time.Since(start).Seconds())
+			result.Rows, result.Elapsed, result.RowsPerSec())
 	}
 
-	// COPY with custom reader for streaming data
+	// Streaming: rows are generated as COPY consumes them, never all in memory
 	fmt.Println("\n✓ Streaming COPY example:")
+	stream := func(yield func(assetRow) bool) {
+		for i := 0; i < 5000; i++ {
+			row := rows[i%len(rows)]
+			row.SerialNumber = fmt.Sprintf("STREAM%d%d", time.Now().Unix(), i)
+			row.MacAddress = fmt.Sprintf("AA:BB:CD:%02X:%02X:%02X", i/65536, (i/256)%256, i%256)
+			if !yield(row) {
+				return
+			}
+		}
+	}
+	loader, _ = bulk.NewLoader[assetRow](pool, "assets", bulk.DefaultConfig())
+	result, err = loader.LoadSeq(ctx, stream)
+	if err != nil {
+		log.Printf("Streaming COPY error: %v", err)
+	} else {
+		fmt.Printf("  - Streamed %d assets in %v\n", result.Rows, result.Elapsed)
+	}
 
 	// Clean up test data
 	pool.Exec(ctx, "DELETE FROM assets WHERE manufacturer = 'CopyTest'")
@@ -335,49 +360,32 @@ func copyFromDemo(ctx context.Context, pool *database.Pool) {
 func batchWithPipeline(ctx context.Context, pool *database.Pool) {
 	fmt.Println("\n=== Pipeline Mode (Maximum Throughput) ===")
 
-	// Pipeline mode sends queries without waiting for results
+	// Pipeline mode sends queries without waiting for results; pgx sends a
+	// Batch as one pipeline
 	start := time.Now()
 
-	conn, err := pool.Acquire(ctx)
-	if err != nil {
-		log.Printf("Acquire error: %v", err)
-		return
-	}
-	defer conn.Release()
-
-	// Start pipeline
-	pipeline := conn.Conn().Pipeline()
-
-	// Queue multiple queries
-	results := make([]*pgx.Results, 100)
+	batch := &pgx.Batch{}
 	for i := 0; i < 100; i++ {
-		results[i] = pipeline.Query(ctx, `
+		batch.Queue(`
 			UPDATE assets
 			SET last_seen = NOW(),
 			    telemetry = telemetry || $1
-			WHERE asset_type = $2
-			LIMIT 10
+			WHERE id IN (SELECT id FROM assets WHERE asset_type = $2 LIMIT 10)
 		`, fmt.Sprintf(`{"pipeline": %d}`, i), "sensor")
 	}
 
-	// Execute pipeline
-	err = pipeline.Sync(ctx)
-	if err != nil {
-		log.Printf("Pipeline sync error: %v", err)
-		return
-	}
+	results := pool.SendBatch(ctx, batch)
 
-	// Process results
+	// Process results, in the order the queries were queued
 	totalUpdated := int64(0)
-	for _, res := range results {
-		tag, err := res.Close()
+	for i := 0; i < batch.Len(); i++ {
+		tag, err := results.Exec()
 		if err == nil {
 			totalUpdated += tag.RowsAffected()
 		}
 	}
 
-	err = pipeline.Close()
-	if err == nil {
+	if err := results.Close(); err == nil {
 		fmt.Printf("✓ Pipeline updated %d rows in %v\n",
 			totalUpdated, time.Since(start))
 	}
@@ -4,17 +4,26 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
 	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/explain"
+	"roguh.com/postgres_playground/pkg/lock"
+	"roguh.com/postgres_playground/pkg/matview"
+	"roguh.com/postgres_playground/pkg/notify"
+	"roguh.com/postgres_playground/pkg/partman"
 )
 
 func main() {
 	ctx := context.Background()
-	pool, err := database.NewPool(ctx, database.DefaultConfig())
+	cfg, err := database.LoadConfig("")
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	pool, err := database.NewPool(ctx, cfg)
 	if err != nil {
 		log.Fatal("Failed to create pool:", err)
 	}
@@ -23,7 +32,7 @@ func main() {
 	fmt.Println("🚀 PostgreSQL Advanced Patterns\n")
 
 	partitioningDemo(ctx, pool)
-	listenNotifyDemo(ctx, pool)
+	listenNotifyDemo(ctx, cfg, pool)
 	advisoryLocksDemo(ctx, pool)
 	ctasAndMaterializedViews(ctx, pool)
 	queryOptimization(ctx, pool)
@@ -32,48 +41,33 @@ func main() {
 func partitioningDemo(ctx context.Context, pool *database.Pool) {
 	fmt.Println("=== Partitioning for Scale ===")
 
-	// Create partitioned table for telemetry data
-	err := database.WithTx(ctx, pool, func(tx pgx.Tx) error {
-		// Create parent table
-		_, err := tx.Exec(ctx, `
-			CREATE TABLE IF NOT EXISTS telemetry_data (
-				asset_id UUID NOT NULL,
-				timestamp TIMESTAMPTZ NOT NULL,
-				metrics JSONB NOT NULL,
-				PRIMARY KEY (asset_id, timestamp)
-			) PARTITION BY RANGE (timestamp)
-		`)
-		if err != nil {
-			return err
-		}
-
-		// Create monthly partitions
-		now := time.Now()
-		for i := 0; i < 3; i++ {
-			month := now.AddDate(0, -i, 0)
-			start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
-			end := start.AddDate(0, 1, 0)
-
-			partName := fmt.Sprintf("telemetry_data_%s", start.Format("2006_01"))
-			_, err = tx.Exec(ctx, fmt.Sprintf(`
-				CREATE TABLE IF NOT EXISTS %s
-				PARTITION OF telemetry_data
-				FOR VALUES FROM ('%s') TO ('%s')
-			`, partName, start.Format(time.RFC3339), end.Format(time.RFC3339)))
-
-			if err != nil {
-				// Partition might already exist
-				continue
-			}
-		}
-		return nil
-	})
-
+	// Create the parent table; partman takes care of the partitions
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS telemetry_data (
+			asset_id UUID NOT NULL,
+			timestamp TIMESTAMPTZ NOT NULL,
+			metrics JSONB NOT NULL,
+			PRIMARY KEY (asset_id, timestamp)
+		) PARTITION BY RANGE (timestamp)
+	`)
 	if err != nil {
 		log.Printf("Partitioning setup error: %v", err)
 		return
 	}
 
+	// Current month + 3 ahead, plus a default partition to catch the rest
+	manager := partman.New(pool)
+	tc := partman.DefaultTableConfig("telemetry_data")
+	tc.DefaultPartition = true
+	actions, err := manager.Maintain(ctx, tc)
+	if err != nil {
+		log.Printf("Partition maintenance error: %v", err)
+		return
+	}
+	for _, action := range actions {
+		fmt.Printf("  - %s\n", action)
+	}
+
 	fmt.Println("✓ Created partitioned telemetry table")
 
 	// Insert data across partitions
@@ -96,6 +90,18 @@ func partitioningDemo(ctx context.Context, pool *database.Pool) {
 	br := pool.SendBatch(ctx, batch)
 	br.Close()
 
+	// Older rows landed in the default partition; maintaining again gives
+	// them partitions of their own and moves them over
+	actions, err = manager.Maintain(ctx, tc)
+	if err != nil {
+		log.Printf("Partition maintenance error: %v", err)
+		return
+	}
+	fmt.Println("✓ Moved rows out of the default partition:")
+	for _, action := range actions {
+		fmt.Printf("  - %s\n", action)
+	}
+
 	// Query partition info
 	var partitionInfo []struct {
 		tableName string
@@ -131,102 +137,70 @@ func partitioningDemo(ctx context.Context, pool *database.Pool) {
 	pool.Exec(ctx, "DROP TABLE IF EXISTS telemetry_data CASCADE")
 }
 
-func listenNotifyDemo(ctx context.Context, pool *database.Pool) {
+func listenNotifyDemo(ctx context.Context, cfg *database.Config, pool *database.Pool) {
 	fmt.Println("\n=== LISTEN/NOTIFY for Real-time Events ===")
 
-	// Create notification trigger
-	_, err := pool.Exec(ctx, `
-		CREATE OR REPLACE FUNCTION notify_asset_change()
-		RETURNS trigger AS $$
-		BEGIN
-			PERFORM pg_notify(
-				'asset_updates',
-				json_build_object(
-					'action', TG_OP,
-					'asset_id', NEW.id,
-					'serial', NEW.serial_number,
-					'status', NEW.status,
-					'timestamp', NOW()
-				)::text
-			);
-			RETURN NEW;
-		END;
-		$$ LANGUAGE plpgsql
-	`)
+	// The notify_row_change() triggers from migration 002 publish every change
+	// to sites and assets. The listener has its own connection and re-LISTENs
+	// after drops.
+	listener, err := notify.NewListener(cfg, notify.DefaultOptions())
 	if err != nil {
-		log.Printf("Create function error: %v", err)
+		log.Printf("Listener error: %v", err)
 		return
 	}
+	listenCtx, stopListening := context.WithCancel(ctx)
+	defer stopListening()
+	go listener.Run(listenCtx)
 
-	// Attach trigger
-	pool.Exec(ctx, `
-		DROP TRIGGER IF EXISTS asset_update_notify ON assets;
-		CREATE TRIGGER asset_update_notify
-		AFTER INSERT OR UPDATE ON assets
-		FOR EACH ROW EXECUTE FUNCTION notify_asset_change()
-	`)
-
-	// Start listener
-	conn, err := pool.Acquire(ctx)
-	if err != nil {
-		log.Printf("Acquire error: %v", err)
-		return
-	}
-	defer conn.Release()
-
-	_, err = conn.Exec(ctx, "LISTEN asset_updates")
+	subCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	sub, err := listener.Subscribe(subCtx, notify.AssetChanges)
 	if err != nil {
 		log.Printf("Listen error: %v", err)
 		return
 	}
+	defer sub.Close()
 
 	fmt.Println("✓ Listening for asset updates...")
 
-	// Goroutine to receive notifications
-	notifyChan := make(chan *pgconn.Notification, 5)
-	go func() {
-		for n := range notifyChan {
-			fmt.Printf("  📢 Received: %s\n", n.Payload)
-		}
-	}()
-
-	// Listen for notifications
 	go func() {
-		for i := 0; i < 3; i++ {
-			notification, err := conn.Conn().WaitForNotification(ctx)
+		for ev := range sub.C {
+			if ev.Missed {
+				fmt.Println("  ⚠️ Reconnected, notifications may have been missed")
+				continue
+			}
+			change, err := notify.DecodeAssetEvent(ev)
 			if err != nil {
-				return
+				log.Printf("Decode error: %v", err)
+				continue
 			}
-			notifyChan <- notification
+			if change.Truncated {
+				fmt.Printf("  📢 %s asset %s (payload too large, re-read it)\n", change.Op, change.ID)
+				continue
+			}
+			fmt.Printf("  📢 %s asset %s: status=%s changed=%v\n", change.Op, change.Row.SerialNumber, change.Row.Status, change.Changed)
 		}
-		close(notifyChan)
 	}()
 
 	// Trigger some updates
-	time.Sleep(100 * time.Millisecond)
-
 	for i := 0; i < 3; i++ {
 		_, err = pool.Exec(ctx, `
 			UPDATE assets
 			SET status = $1, last_seen = NOW()
-			WHERE asset_type = 'sensor'
-			LIMIT 1
+			WHERE id = (SELECT id FROM assets WHERE asset_type = 'sensor' LIMIT 1)
 		`, []string{"active", "maintenance", "offline"}[i%3])
 
 		time.Sleep(200 * time.Millisecond)
 	}
 
 	time.Sleep(500 * time.Millisecond)
-
-	// Clean up
-	pool.Exec(ctx, "DROP TRIGGER IF EXISTS asset_update_notify ON assets")
-	pool.Exec(ctx, "DROP FUNCTION IF EXISTS notify_asset_change()")
 }
 
 func advisoryLocksDemo(ctx context.Context, pool *database.Pool) {
 	fmt.Println("\n=== Advisory Locks for Distributed Coordination ===")
 
-	// Demonstrate exclusive advisory locks
+	// Demonstrate exclusive advisory locks; keys are hashed from names
+	locker := lock.New(pool, "examples")
 	var wg sync.WaitGroup
 	results := make(chan string, 5)
 
@@ -236,30 +210,22 @@ func advisoryLocksDemo(ctx context.Context, pool *database.Pool) {
 		go func(workerID int) {
 			defer wg.Done()
 
-			conn, err := pool.Acquire(ctx)
+			lease, err := locker.TryLock(ctx, "site-rollup")
 			if err != nil {
-				results <- fmt.Sprintf("Worker %d: failed to acquire connection", workerID)
+				results <- fmt.Sprintf("Worker %d: lock error: %v", workerID, err)
 				return
 			}
-			defer conn.Release()
-
-			// Try to get advisory lock (using a hash of resource ID)
-			lockID := int64(12345) // Simulated resource ID
-
-			var acquired bool
-			err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", lockID).Scan(&acquired)
-			if err != nil || !acquired {
+			if lease == nil {
 				results <- fmt.Sprintf("Worker %d: couldn't acquire lock", workerID)
 				return
 			}
+			defer lease.Close()
 
 			// Do "work" with the locked resource
-			results <- fmt.Sprintf("Worker %d: got lock, processing...", workerID)
+			results <- fmt.Sprintf("Worker %d: got lock %d, processing...", workerID, lease.Key())
 			time.Sleep(200 * time.Millisecond)
 
-			// Release lock
-			conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", lockID)
-			results <- fmt.Sprintf("Worker %d: released lock", workerID)
+			results <- fmt.Sprintf("Worker %d: releasing lock", workerID)
 		}(i)
 	}
 
@@ -308,44 +274,52 @@ func ctasAndMaterializedViews(ctx context.Context, pool *database.Pool) {
 		fmt.Printf("✓ Created summary table with CTAS in %v\n", time.Since(start))
 	}
 
-	// Create materialized view for complex aggregations
+	// Register the materialized view with its refresh schedule and the
+	// unique key REFRESH ... CONCURRENTLY needs
 	start = time.Now()
-	_, err = pool.Exec(ctx, `
-		CREATE MATERIALIZED VIEW IF NOT EXISTS mv_asset_telemetry_stats AS
-		WITH latest_telemetry AS (
-			SELECT DISTINCT ON (id)
-				id,
-				telemetry,
-				last_seen
-			FROM assets
-			ORDER BY id, last_seen DESC
-		)
-		SELECT
-			asset_type,
-			COUNT(*) as total_assets,
-			AVG((telemetry->'metrics'->'cpu'->>'value')::float) as avg_cpu,
-			MAX((telemetry->'metrics'->'cpu'->>'value')::float) as max_cpu,
-			percentile_cont(0.95) WITHIN GROUP (
-				ORDER BY (telemetry->'metrics'->'cpu'->>'value')::float
-			) as p95_cpu,
-			COUNT(*) FILTER (
-				WHERE last_seen > NOW() - INTERVAL '1 hour'
-			) as recently_seen
-		FROM assets a
-		JOIN latest_telemetry lt ON lt.id = a.id
-		WHERE telemetry->'metrics'->'cpu'->>'value' IS NOT NULL
-		GROUP BY asset_type
-	`)
-
+	views := matview.NewRegistry(pool, matview.DefaultConfig())
+	err = views.Register(matview.View{
+		Name: "mv_asset_telemetry_stats",
+		Definition: `
+			WITH latest_telemetry AS (
+				SELECT DISTINCT ON (id)
+					id,
+					telemetry,
+					last_seen
+				FROM assets
+				ORDER BY id, last_seen DESC
+			)
+			SELECT
+				asset_type,
+				COUNT(*) as total_assets,
+				AVG((telemetry->'metrics'->'cpu'->>'value')::float) as avg_cpu,
+				MAX((telemetry->'metrics'->'cpu'->>'value')::float) as max_cpu,
+				percentile_cont(0.95) WITHIN GROUP (
+					ORDER BY (telemetry->'metrics'->'cpu'->>'value')::float
+				) as p95_cpu,
+				COUNT(*) FILTER (
+					WHERE last_seen > NOW() - INTERVAL '1 hour'
+				) as recently_seen
+			FROM assets a
+			JOIN latest_telemetry lt ON lt.id = a.id
+			WHERE telemetry->'metrics'->'cpu'->>'value' IS NOT NULL
+			GROUP BY asset_type
+		`,
+		UniqueKey: []string{"asset_type"},
+		Interval:  5 * time.Minute,
+	})
 	if err == nil {
-		fmt.Printf("✓ Created materialized view in %v\n", time.Since(start))
-
-		// Create index on materialized view
-		pool.Exec(ctx, `
-			CREATE INDEX IF NOT EXISTS idx_mv_asset_type
-			ON mv_asset_telemetry_stats(asset_type)
-		`)
+		err = views.Ensure(ctx)
+	}
+	if err == nil {
+		// First refresh populates it; it can't be concurrent yet
+		_, err = views.Refresh(ctx, "mv_asset_telemetry_stats")
+	}
+	if err != nil {
+		log.Printf("Materialized view error: %v", err)
+		return
 	}
+	fmt.Printf("✓ Created materialized view in %v\n", time.Since(start))
 
 	// Query materialized view
 	rows, err := pool.Query(ctx, `
@@ -374,22 +348,23 @@ func ctasAndMaterializedViews(ctx context.Context, pool *database.Pool) {
 	// Refresh strategies
 	fmt.Println("\n✓ Refresh strategies:")
 
-	// Concurrent refresh (non-blocking)
+	// Concurrent refresh (non-blocking); readers keep using the old contents
 	start = time.Now()
-	_, err = pool.Exec(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY mv_asset_telemetry_stats")
-	if err != nil {
-		// Need unique index for concurrent refresh
-		pool.Exec(ctx, `
-			CREATE UNIQUE INDEX IF NOT EXISTS idx_mv_asset_type_unique
-			ON mv_asset_telemetry_stats(asset_type)
-		`)
-		pool.Exec(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY mv_asset_telemetry_stats")
+	if _, err := views.Refresh(ctx, "mv_asset_telemetry_stats"); err != nil {
+		log.Printf("Refresh failed: %v", err)
 	}
 	fmt.Printf("  - Concurrent refresh: %v\n", time.Since(start))
 
+	// Readers can check how old the data is before trusting it
+	if status, err := views.Status(ctx, "mv_asset_telemetry_stats"); err == nil {
+		fmt.Printf("  - Refreshed %d times, last took %v, age %v, stale=%v\n",
+			status.Refreshes, status.LastDuration.Round(time.Millisecond), status.Age.Round(time.Millisecond), status.Stale)
+	}
+
 	// Clean up
 	pool.Exec(ctx, "DROP TABLE IF EXISTS site_summary")
 	pool.Exec(ctx, "DROP MATERIALIZED VIEW IF EXISTS mv_asset_telemetry_stats")
+	pool.Exec(ctx, "DELETE FROM matview_refreshes WHERE view_name = $1", "mv_asset_telemetry_stats")
 }
 
 func queryOptimization(ctx context.Context, pool *database.Pool) {
@@ -416,9 +391,7 @@ func queryOptimization(ctx context.Context, pool *database.Pool) {
 	// 2. Query planning analysis
 	fmt.Println("\n✓ Query plan analysis:")
 
-	var plan string
-	err = pool.QueryRow(ctx, `
-		EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON)
+	plan, err := explain.Analyze(ctx, pool, `
 		SELECT s.name, COUNT(a.id) as asset_count
 		FROM sites s
 		JOIN assets a ON a.site_id = s.id
@@ -427,11 +400,15 @@ func queryOptimization(ctx context.Context, pool *database.Pool) {
 		GROUP BY s.id, s.name
 		ORDER BY asset_count DESC
 		LIMIT 10
-	`).Scan(&plan)
-
-	if err == nil {
-		// Parse and display key metrics from JSON plan
-		fmt.Println("  - Query executed (JSON plan available)")
+	`)
+	if err != nil {
+		log.Printf("Explain error: %v", err)
+	} else {
+		findings := plan.Check(explain.DefaultThresholds())
+		plan.Render(os.Stdout, findings)
+		if len(findings) == 0 {
+			fmt.Println("  - No plan problems found")
+		}
 	}
 
 	// 3. Common Table Expressions for complex queries
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/leader"
+)
+
+const election = "stale-asset-sweep"
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg, err := database.LoadConfig("")
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	pool, err := database.NewPool(ctx, cfg)
+	if err != nil {
+		log.Fatal("Failed to create pool:", err)
+	}
+	defer pool.Close()
+
+	fmt.Println("👑 Leader Election on Advisory Locks")
+	fmt.Println()
+
+	// Three candidates standing in for three worker replicas
+	electors := make([]*leader.Elector, 3)
+	done := make(chan struct{}, len(electors))
+	for i := range electors {
+		lcfg := leader.DefaultConfig(election)
+		lcfg.ID = fmt.Sprintf("worker-%d", i)
+		lcfg.RetryInterval = 500 * time.Millisecond
+		lcfg.HeartbeatInterval = 500 * time.Millisecond
+		id := lcfg.ID
+		lcfg.OnElected = func(ctx context.Context) {
+			fmt.Printf("  ✓ %s elected, sweeping stale assets\n", id)
+			<-ctx.Done()
+		}
+		lcfg.OnRevoked = func() {
+			fmt.Printf("  ✗ %s revoked\n", id)
+		}
+
+		e, err := leader.New(pool, lcfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		electors[i] = e
+		go func() {
+			e.Run(ctx)
+			done <- struct{}{}
+		}()
+	}
+
+	first := waitForLeader(electors, 5*time.Second)
+	if first < 0 {
+		log.Fatal("No leader elected")
+	}
+
+	// Kill the leader's session from the outside, as a crash or a DBA would
+	pid, err := lockHolderPID(ctx, pool)
+	if err != nil {
+		log.Fatal("Failed to find leader backend:", err)
+	}
+	fmt.Printf("\n💀 Terminating worker-%d's backend (pid %d)\n", first, pid)
+	if _, err := pool.Exec(ctx, "SELECT pg_terminate_backend($1)", pid); err != nil {
+		log.Fatal("Failed to terminate backend:", err)
+	}
+
+	time.Sleep(3 * time.Second)
+	next := waitForLeader(electors, 5*time.Second)
+	switch {
+	case next < 0:
+		fmt.Println("\n✗ No candidate took over")
+	case next == first:
+		fmt.Printf("\n✓ worker-%d stepped down and won the lock back\n", next)
+	default:
+		fmt.Printf("\n✓ worker-%d took over from worker-%d\n", next, first)
+	}
+
+	leaders := 0
+	for _, e := range electors {
+		if e.IsLeader() {
+			leaders++
+		}
+	}
+	fmt.Printf("✓ %d leader(s) now\n", leaders)
+
+	cancel()
+	for range electors {
+		<-done
+	}
+}
+
+// waitForLeader returns the index of the current leader, or -1 on timeout
+func waitForLeader(electors []*leader.Elector, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for i, e := range electors {
+			if e.IsLeader() {
+				return i
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return -1
+}
+
+// lockHolderPID finds the backend holding the election's advisory lock
+func lockHolderPID(ctx context.Context, pool *database.Pool) (int32, error) {
+	var pid int32
+	err := pool.QueryRow(ctx, `
+		SELECT pid FROM pg_locks
+		WHERE locktype = 'advisory' AND objsubid = 1 AND granted
+			AND (classid::bigint << 32 | objid::bigint) = $1
+	`, leader.Key(election)).Scan(&pid)
+	return pid, err
+}
@@ -0,0 +1,5 @@
+#!/bin/sh
+# Allow the streaming replica (docker-compose --profile replica) to connect.
+# Runs once, when the pgdata volume is first initialized.
+set -e
+echo "host replication all all scram-sha-256" >> "$PGDATA/pg_hba.conf"
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS assets_notify_change ON assets;
+DROP TRIGGER IF EXISTS sites_notify_change ON sites;
+
+DROP FUNCTION IF EXISTS notify_row_change();
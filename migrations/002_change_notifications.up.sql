@@ -0,0 +1,57 @@
+-- Change notifications: every INSERT/UPDATE/DELETE on sites and assets sends
+-- a JSON payload on site_changes / asset_changes:
+--   {"op": "UPDATE", "table": "assets", "id": "...", "changed": ["status"], "row": {...}, "at": "..."}
+-- NOTIFY payloads must be under 8000 bytes, so larger rows are sent as
+-- {"op", "table", "id", "truncated": true, "at"} and listeners re-read the row.
+CREATE OR REPLACE FUNCTION notify_row_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    row_data JSONB;
+    old_data JSONB;
+    changed TEXT[];
+    payload TEXT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        row_data := to_jsonb(OLD);
+    ELSE
+        row_data := to_jsonb(NEW);
+    END IF;
+
+    IF TG_OP = 'UPDATE' THEN
+        old_data := to_jsonb(OLD);
+        SELECT array_agg(key ORDER BY key) INTO changed
+        FROM jsonb_each(row_data) AS n(key, value)
+        WHERE n.value IS DISTINCT FROM old_data -> n.key;
+    END IF;
+
+    payload := jsonb_build_object(
+        'op', TG_OP,
+        'table', TG_TABLE_NAME,
+        'id', row_data ->> 'id',
+        'changed', COALESCE(to_jsonb(changed), '[]'::jsonb),
+        'row', row_data,
+        'at', NOW()
+    )::text;
+
+    IF octet_length(payload) >= 8000 THEN
+        payload := jsonb_build_object(
+            'op', TG_OP,
+            'table', TG_TABLE_NAME,
+            'id', row_data ->> 'id',
+            'truncated', true,
+            'at', NOW()
+        )::text;
+    END IF;
+
+    PERFORM pg_notify(TG_ARGV[0], payload);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER sites_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON sites
+    FOR EACH ROW EXECUTE FUNCTION notify_row_change('site_changes');
+
+CREATE TRIGGER assets_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON assets
+    FOR EACH ROW EXECUTE FUNCTION notify_row_change('asset_changes');
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_outbox_archive_delivered_at;
+DROP TABLE IF EXISTS outbox_archive;
+
+DROP INDEX IF EXISTS idx_outbox_key_id;
+DROP INDEX IF EXISTS idx_outbox_next_attempt;
+DROP TABLE IF EXISTS outbox;
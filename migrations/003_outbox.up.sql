@@ -0,0 +1,33 @@
+-- Transactional outbox: rows are written in the same transaction as the
+-- change they describe and delivered downstream by a relay worker
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL,
+    key TEXT,
+    payload JSONB NOT NULL,
+    headers JSONB NOT NULL DEFAULT '{}',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_outbox_next_attempt ON outbox(next_attempt_at, id);
+
+-- Lets the relay find the oldest undelivered row per key, so later rows for
+-- the same key wait across batches and across relays
+CREATE INDEX idx_outbox_key_id ON outbox(key, id) WHERE key IS NOT NULL;
+
+-- Delivered rows, when the relay is configured to archive instead of delete
+CREATE TABLE outbox_archive (
+    id BIGINT PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL,
+    key TEXT,
+    payload JSONB NOT NULL,
+    headers JSONB NOT NULL,
+    attempts INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    delivered_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_outbox_archive_delivered_at ON outbox_archive(delivered_at);
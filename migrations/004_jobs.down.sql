@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS jobs_notify_ready ON jobs;
+DROP FUNCTION IF EXISTS notify_job_ready();
+DROP TRIGGER IF EXISTS update_jobs_updated_at ON jobs;
+
+DROP INDEX IF EXISTS idx_jobs_finished_at;
+DROP INDEX IF EXISTS idx_jobs_running;
+DROP INDEX IF EXISTS idx_jobs_pending;
+DROP TABLE IF EXISTS jobs;
@@ -0,0 +1,45 @@
+-- Job queue: workers claim pending jobs with FOR UPDATE SKIP LOCKED.
+-- A claimed job is 'running' until locked_until; if the worker dies it is
+-- picked up again after that. Jobs out of attempts end up 'dead'.
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    queue VARCHAR(100) NOT NULL DEFAULT 'default',
+    kind VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    priority SMALLINT NOT NULL DEFAULT 0,
+    state VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (state IN ('pending', 'running', 'done', 'dead')),
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 5,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_by TEXT,
+    locked_until TIMESTAMPTZ,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+
+-- Claim order: highest priority first, then earliest run_at
+CREATE INDEX idx_jobs_pending ON jobs(queue, priority DESC, run_at, id) WHERE state = 'pending';
+CREATE INDEX idx_jobs_running ON jobs(locked_until) WHERE state = 'running';
+CREATE INDEX idx_jobs_finished_at ON jobs(finished_at) WHERE state IN ('done', 'dead');
+
+CREATE TRIGGER update_jobs_updated_at BEFORE UPDATE ON jobs
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at();
+
+-- Wake idle workers. NOTIFY collapses identical payloads within a
+-- transaction, so a bulk enqueue sends one notification per queue.
+CREATE OR REPLACE FUNCTION notify_job_ready()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.state = 'pending' THEN
+        PERFORM pg_notify('jobs', NEW.queue);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER jobs_notify_ready
+    AFTER INSERT OR UPDATE OF state ON jobs
+    FOR EACH ROW EXECUTE FUNCTION notify_job_ready();
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS update_matview_refreshes_updated_at ON matview_refreshes;
+DROP TABLE IF EXISTS matview_refreshes;
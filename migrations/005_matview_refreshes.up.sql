@@ -0,0 +1,16 @@
+-- Refresh bookkeeping for materialized views managed by pkg/matview.
+-- Readers can check last_success_at to decide whether to trust a view.
+CREATE TABLE matview_refreshes (
+    view_name TEXT PRIMARY KEY,
+    refresh_interval INTERVAL NOT NULL,
+    last_started_at TIMESTAMPTZ,
+    last_success_at TIMESTAMPTZ,
+    last_duration INTERVAL,
+    last_error TEXT,
+    refreshes BIGINT NOT NULL DEFAULT 0,
+    failures BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER update_matview_refreshes_updated_at BEFORE UPDATE ON matview_refreshes
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at();
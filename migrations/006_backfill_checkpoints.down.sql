@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS update_backfill_checkpoints_updated_at ON backfill_checkpoints;
+DROP TABLE IF EXISTS backfill_checkpoints;
@@ -0,0 +1,16 @@
+-- Progress of chunked backfills run by pkg/backfill. Each batch commits its
+-- checkpoint in the same transaction as its changes, so a restarted run
+-- resumes right after the last committed batch.
+CREATE TABLE backfill_checkpoints (
+    name TEXT PRIMARY KEY,
+    table_name TEXT NOT NULL,
+    last_key TEXT,
+    rows_done BIGINT NOT NULL DEFAULT 0,
+    batches BIGINT NOT NULL DEFAULT 0,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE TRIGGER update_backfill_checkpoints_updated_at BEFORE UPDATE ON backfill_checkpoints
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at();
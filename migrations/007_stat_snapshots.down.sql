@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS stat_snapshot_statements;
+DROP TABLE IF EXISTS stat_snapshots;
@@ -0,0 +1,26 @@
+-- Saved copies of pg_stat_statements taken by cmd/pgstat. The view only
+-- holds running totals since the last reset, so diffing two snapshots shows
+-- what ran in between, e.g. during a deploy or a benchmark. stats_reset is
+-- pg_stat_statements_info.stats_reset at the time, so a diff can tell when
+-- the totals were reset between two snapshots.
+CREATE TABLE stat_snapshots (
+    id BIGSERIAL PRIMARY KEY,
+    label TEXT NOT NULL DEFAULT '',
+    taken_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    stats_reset TIMESTAMPTZ
+);
+
+CREATE TABLE stat_snapshot_statements (
+    snapshot_id BIGINT NOT NULL REFERENCES stat_snapshots(id) ON DELETE CASCADE,
+    userid OID NOT NULL,
+    queryid BIGINT NOT NULL,
+    query TEXT NOT NULL,
+    calls BIGINT NOT NULL,
+    total_exec_time DOUBLE PRECISION NOT NULL,
+    rows BIGINT NOT NULL,
+    shared_blks_hit BIGINT NOT NULL,
+    shared_blks_read BIGINT NOT NULL,
+    temp_blks_read BIGINT NOT NULL,
+    temp_blks_written BIGINT NOT NULL,
+    PRIMARY KEY (snapshot_id, userid, queryid)
+);
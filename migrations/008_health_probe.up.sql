@@ -0,0 +1,6 @@
+-- Written by the health package's writable check: a primary whose disk is
+-- full still answers reads, so only a real write notices
+CREATE TABLE health_probes (
+    name TEXT PRIMARY KEY,
+    probed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
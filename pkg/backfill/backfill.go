@@ -0,0 +1,290 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// Job describes a chunked UPDATE or DELETE over one table
+type Job struct {
+	Name   string // checkpoint name; reusing it resumes the run (required)
+	Table  string // optionally schema-qualified
+	Key    string // unique, ordered column to walk (default "id")
+	Where  string // optional filter, e.g. "asset_type = 'router'"
+	Set    string // UPDATE assignments, e.g. "status = 'retired'"; ignored for Delete
+	Delete bool   // delete matching rows instead of updating them
+
+	BatchSize     int           // rows per transaction
+	Pause         time.Duration // sleep between batches
+	MaxRowsPerSec float64       // 0 means unlimited
+	MaxLag        time.Duration // wait while replicas are further behind (0 ignores lag)
+	Lag           LagFunc       // how lag is measured (default ReplicationLag on the pool)
+
+	Progress func(Progress) // called after each committed batch
+	Logger   *slog.Logger
+}
+
+// LagFunc reports how far behind replicas are
+type LagFunc func(ctx context.Context) (time.Duration, error)
+
+// Progress reports a run after each batch
+type Progress struct {
+	Batches int64  // committed, including ones from earlier runs
+	Rows    int64  // rows changed, including earlier runs
+	LastKey string // where the next batch starts
+	Elapsed time.Duration
+}
+
+// Result summarizes a run
+type Result struct {
+	Batches  int64 // committed in this run
+	Rows     int64 // changed in this run
+	Resumed  bool  // picked up from a checkpoint
+	Finished bool  // walked the whole table (false if ctx ended first)
+	Elapsed  time.Duration
+}
+
+// Runner walks a table by key in keyset order and changes it one short
+// transaction per batch, so locks are held briefly and autovacuum and
+// replicas can keep up. Each batch records its checkpoint in
+// backfill_checkpoints (migration 006) in the same transaction, so a
+// crashed or cancelled run resumes where it stopped.
+type Runner struct {
+	pool    *database.Pool
+	job     Job
+	keyType string
+	last    *string // last key done, nil before the first batch
+}
+
+// New validates job and fills in defaults
+func New(pool *database.Pool, job Job) (*Runner, error) {
+	switch {
+	case job.Name == "":
+		return nil, errors.New("backfill: job name is required")
+	case job.Table == "":
+		return nil, errors.New("backfill: table is required")
+	case !job.Delete && job.Set == "":
+		return nil, fmt.Errorf("backfill %s: Set is required for an update", job.Name)
+	}
+	if job.Key == "" {
+		job.Key = "id"
+	}
+	if job.BatchSize <= 0 {
+		job.BatchSize = 1000
+	}
+	if job.MaxLag > 0 && job.Lag == nil {
+		job.Lag = ReplicationLag(pool)
+	}
+	if job.Logger == nil {
+		job.Logger = slog.Default()
+	}
+	job.Logger = job.Logger.With("backfill", job.Name)
+	return &Runner{pool: pool, job: job}, nil
+}
+
+// Run processes batches until the table is done or ctx is cancelled. A
+// finished job is not run again; Reset it first to start over.
+func (r *Runner) Run(ctx context.Context) (Result, error) {
+	start := time.Now()
+	var result Result
+
+	if err := r.pool.QueryRow(ctx, `
+		SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attname = $2 AND NOT attisdropped
+	`, r.ident().Sanitize(), r.job.Key).Scan(&r.keyType); err != nil {
+		return result, fmt.Errorf("look up key column %s.%s: %w", r.job.Table, r.job.Key, err)
+	}
+
+	cp, err := r.checkpoint(ctx)
+	if err != nil {
+		return result, err
+	}
+	if cp.finished {
+		r.job.Logger.Info("backfill already finished", "rows", cp.rows)
+		result.Finished = true
+		return result, nil
+	}
+	r.last = cp.lastKey
+	result.Resumed = cp.lastKey != nil
+	if result.Resumed {
+		r.job.Logger.Info("resuming backfill", "after", *cp.lastKey, "rows", cp.rows)
+	}
+
+	for {
+		if err := r.waitForReplicas(ctx); err != nil {
+			return r.done(result, start), err
+		}
+
+		sql := r.batchSQL()
+
+		var scanned, changed int64
+		var lastKey *string
+		_, err := database.WithTxOptions(ctx, r.pool, pgx.TxOptions{}, database.DefaultRetryPolicy(), func(tx pgx.Tx) error {
+			args := []any{r.job.BatchSize}
+			if r.last != nil {
+				args = append(args, *r.last)
+			}
+			if err := tx.QueryRow(ctx, sql, args...).Scan(&lastKey, &scanned, &changed); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `
+				INSERT INTO backfill_checkpoints (name, table_name, last_key, rows_done, batches, finished_at)
+				VALUES ($1, $2, $3, $4, 1, CASE WHEN $5 THEN NOW() END)
+				ON CONFLICT (name) DO UPDATE SET
+					last_key = COALESCE(EXCLUDED.last_key, backfill_checkpoints.last_key),
+					rows_done = backfill_checkpoints.rows_done + EXCLUDED.rows_done,
+					batches = backfill_checkpoints.batches + 1,
+					finished_at = EXCLUDED.finished_at
+			`, r.job.Name, r.job.Table, lastKey, changed, scanned < int64(r.job.BatchSize))
+			return err
+		})
+		if err != nil {
+			return r.done(result, start), fmt.Errorf("backfill %s batch after %v: %w", r.job.Name, deref(r.last), err)
+		}
+
+		result.Batches++
+		result.Rows += changed
+		if lastKey != nil {
+			r.last = lastKey
+		}
+		cp.batches++
+		cp.rows += changed
+		if r.job.Progress != nil {
+			r.job.Progress(Progress{Batches: cp.batches, Rows: cp.rows, LastKey: deref(r.last), Elapsed: time.Since(start)})
+		}
+		r.job.Logger.Debug("backfill batch committed", "rows", changed, "last_key", deref(r.last))
+
+		if scanned < int64(r.job.BatchSize) {
+			result.Finished = true
+			r.job.Logger.Info("backfill finished", "rows", cp.rows, "batches", cp.batches)
+			return r.done(result, start), nil
+		}
+
+		if err := r.throttle(ctx, start, result.Rows); err != nil {
+			return r.done(result, start), err
+		}
+	}
+}
+
+func (r *Runner) done(result Result, start time.Time) Result {
+	result.Elapsed = time.Since(start)
+	return result
+}
+
+func (r *Runner) ident() pgx.Identifier { return pgx.Identifier(strings.Split(r.job.Table, ".")) }
+
+// batchSQL picks the next BatchSize keys after $2 and changes those rows.
+// It returns the last key of the batch, how many keys were picked (fewer
+// than BatchSize means the end of the table) and how many rows changed.
+// The first batch has no lower bound, so the query stays index-friendly
+// without an "$2 IS NULL OR" branch.
+func (r *Runner) batchSQL() string {
+	table := r.ident().Sanitize()
+	key := pgx.Identifier{r.job.Key}.Sanitize()
+
+	var conds []string
+	if r.last != nil {
+		conds = append(conds, fmt.Sprintf("%s > $2::text::%s", key, r.keyType))
+	}
+	if r.job.Where != "" {
+		conds = append(conds, "("+r.job.Where+")")
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	change := fmt.Sprintf("UPDATE %s AS t SET %s FROM batch WHERE t.%s = batch.%s RETURNING 1", table, r.job.Set, key, key)
+	if r.job.Delete {
+		change = fmt.Sprintf("DELETE FROM %s AS t USING batch WHERE t.%s = batch.%s RETURNING 1", table, key, key)
+	}
+
+	return fmt.Sprintf(`
+		WITH batch AS (
+			SELECT %[1]s FROM %[2]s %[3]s ORDER BY %[1]s LIMIT $1
+		), changed AS (
+			%[4]s
+		)
+		SELECT
+			(SELECT %[1]s::text FROM batch ORDER BY %[1]s DESC LIMIT 1), -- no max() for uuid
+			(SELECT COUNT(*) FROM batch),
+			(SELECT COUNT(*) FROM changed)
+	`, key, table, where, change)
+}
+
+// throttle sleeps for Pause, and longer if needed to stay under MaxRowsPerSec
+func (r *Runner) throttle(ctx context.Context, start time.Time, rows int64) error {
+	wait := r.job.Pause
+	if r.job.MaxRowsPerSec > 0 {
+		due := time.Duration(float64(rows) / r.job.MaxRowsPerSec * float64(time.Second))
+		wait = max(wait, due-time.Since(start))
+	}
+	return sleep(ctx, wait)
+}
+
+// waitForReplicas holds off while replicas are more than MaxLag behind
+func (r *Runner) waitForReplicas(ctx context.Context) error {
+	if r.job.MaxLag <= 0 {
+		return nil
+	}
+	for {
+		lag, err := r.job.Lag(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			r.job.Logger.Warn("can't measure replication lag; carrying on", "error", err)
+			return nil
+		}
+		if lag <= r.job.MaxLag {
+			return nil
+		}
+		r.job.Logger.Info("replicas behind, waiting", "lag", lag, "max_lag", r.job.MaxLag)
+		if err := sleep(ctx, min(lag-r.job.MaxLag+time.Second, 10*time.Second)); err != nil {
+			return err
+		}
+	}
+}
+
+// ReplicationLag measures the worst replay lag among replicas streaming
+// from pool's server. No replicas means no lag.
+func ReplicationLag(pool *database.Pool) LagFunc {
+	return func(ctx context.Context) (time.Duration, error) {
+		var seconds float64
+		err := pool.QueryRow(ctx, `
+			SELECT COALESCE(EXTRACT(EPOCH FROM max(replay_lag)), 0)::float8 FROM pg_stat_replication
+		`).Scan(&seconds)
+		if err != nil {
+			return 0, fmt.Errorf("query replication lag: %w", err)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,70 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// Checkpoint is a backfill's saved progress
+type Checkpoint struct {
+	Name       string
+	Table      string
+	LastKey    *string // nil before the first batch
+	Rows       int64
+	Batches    int64
+	StartedAt  time.Time
+	UpdatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+type checkpoint struct {
+	lastKey       *string
+	rows, batches int64
+	finished      bool
+}
+
+func (r *Runner) checkpoint(ctx context.Context) (checkpoint, error) {
+	var cp checkpoint
+	var finishedAt *time.Time
+	err := r.pool.QueryRow(ctx, `
+		SELECT last_key, rows_done, batches, finished_at FROM backfill_checkpoints WHERE name = $1
+	`, r.job.Name).Scan(&cp.lastKey, &cp.rows, &cp.batches, &finishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, fmt.Errorf("read checkpoint: %w", err)
+	}
+	cp.finished = finishedAt != nil
+	return cp, nil
+}
+
+// Status returns the saved progress of the named backfill, or nil if it
+// never ran
+func Status(ctx context.Context, pool *database.Pool, name string) (*Checkpoint, error) {
+	var cp Checkpoint
+	err := pool.QueryRow(ctx, `
+		SELECT name, table_name, last_key, rows_done, batches, started_at, updated_at, finished_at
+		FROM backfill_checkpoints WHERE name = $1
+	`, name).Scan(&cp.Name, &cp.Table, &cp.LastKey, &cp.Rows, &cp.Batches, &cp.StartedAt, &cp.UpdatedAt, &cp.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoint: %w", err)
+	}
+	return &cp, nil
+}
+
+// Reset forgets the named backfill's progress, so its next run starts over
+func Reset(ctx context.Context, pool *database.Pool, name string) error {
+	if _, err := pool.Exec(ctx, "DELETE FROM backfill_checkpoints WHERE name = $1", name); err != nil {
+		return fmt.Errorf("reset checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrRolledBack marks an item that ran but was undone when a later item failed
+	ErrRolledBack = errors.New("bulk: rolled back with the rest of the batch")
+	// ErrNotRun marks an item the server skipped after an earlier item failed
+	ErrNotRun = errors.New("bulk: not run, an earlier batch item failed")
+)
+
+// Batcher runs batches and single queries: *database.Pool, pgx.Tx, *pgx.Conn
+type Batcher interface {
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// BatchConfig tunes Batch.Exec
+type BatchConfig struct {
+	// RetryIndividually reruns every item that didn't take effect as its own
+	// statement after the batch fails, so one bad row only loses itself
+	RetryIndividually bool
+}
+
+// ItemResult is the outcome of one queued statement
+type ItemResult struct {
+	Index        int
+	RowsAffected int64
+	Rows         [][]any // RETURNING values, if any
+	Err          error
+	PgErr        *pgconn.PgError // Err as a server error, if it is one
+	Retried      bool            // result comes from the individual retry
+}
+
+// BatchError reports how many items failed; Unwrap gives the first failure
+type BatchError struct {
+	Failed, Total int
+	First         error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("bulk: %d of %d batch items failed: %v", e.Failed, e.Total, e.First)
+}
+
+func (e *BatchError) Unwrap() error { return e.First }
+
+// Batch queues statements like pgx.Batch, but Exec reports every item.
+//
+// A batch runs as one implicit transaction: when an item fails, the items
+// before it are rolled back and the ones after it never run. Exec says so
+// per item (ErrRolledBack, ErrNotRun) instead of losing track, and can
+// retry the survivors individually.
+type Batch struct {
+	items []batchItem
+}
+
+type batchItem struct {
+	sql  string
+	args []any
+}
+
+// Queue adds a statement and returns its index in the results
+func (b *Batch) Queue(sql string, args ...any) int {
+	b.items = append(b.items, batchItem{sql: sql, args: args})
+	return len(b.items) - 1
+}
+
+// Len returns the number of queued statements
+func (b *Batch) Len() int { return len(b.items) }
+
+// Exec sends the batch and returns one result per queued statement. The
+// error is a *BatchError if any item failed in the end. In a transaction,
+// the batch and each retry run under their own savepoint, so a failure
+// doesn't abort the caller's transaction.
+func (b *Batch) Exec(ctx context.Context, db Batcher, cfg BatchConfig) ([]ItemResult, error) {
+	results := make([]ItemResult, len(b.items))
+	for i := range results {
+		results[i].Index = i
+	}
+	if len(b.items) == 0 {
+		return results, nil
+	}
+
+	failed := b.send(ctx, db, results)
+	if failed >= 0 && cfg.RetryIndividually {
+		for i := range results {
+			if results[i].Err == nil {
+				continue
+			}
+			results[i] = b.retry(ctx, db, i)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+
+	var batchErr *BatchError
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		if batchErr == nil {
+			batchErr = &BatchError{Total: len(results), First: fmt.Errorf("item %d: %w", r.Index, r.Err)}
+		}
+		batchErr.Failed++
+	}
+	if batchErr != nil {
+		return results, batchErr
+	}
+	return results, nil
+}
+
+// send runs the whole batch and returns the index of the failed item, or -1
+func (b *Batch) send(ctx context.Context, db Batcher, results []ItemResult) int {
+	failed := -1
+	err := savepoint(ctx, db, func(db Batcher) error {
+		batch := &pgx.Batch{}
+		for _, item := range b.items {
+			batch.Queue(item.sql, item.args...)
+		}
+		br := db.SendBatch(ctx, batch)
+		for i := range b.items {
+			if failed >= 0 {
+				results[i].Err = ErrNotRun
+				continue
+			}
+			rows, err := br.Query()
+			if err == nil {
+				results[i].Rows, results[i].RowsAffected, err = collect(rows)
+			}
+			if err != nil {
+				failed = i
+				setErr(&results[i], err)
+			}
+		}
+		closeErr := br.Close()
+		if failed < 0 && closeErr != nil {
+			// Only the commit at the end of the batch failed
+			failed = len(b.items) - 1
+			setErr(&results[failed], closeErr)
+		}
+		if failed >= 0 {
+			return results[failed].Err
+		}
+		return nil
+	})
+	if failed < 0 && err != nil {
+		// The savepoint itself failed; nothing ran
+		for i := range results {
+			setErr(&results[i], err)
+		}
+		return 0
+	}
+	if failed >= 0 {
+		for i := 0; i < failed; i++ {
+			results[i] = ItemResult{Index: i, Err: ErrRolledBack}
+		}
+	}
+	return failed
+}
+
+func (b *Batch) retry(ctx context.Context, db Batcher, i int) ItemResult {
+	result := ItemResult{Index: i, Retried: true}
+	err := savepoint(ctx, db, func(db Batcher) error {
+		rows, err := db.Query(ctx, b.items[i].sql, b.items[i].args...)
+		if err != nil {
+			return err
+		}
+		result.Rows, result.RowsAffected, err = collect(rows)
+		return err
+	})
+	if err != nil {
+		result.Rows, result.RowsAffected = nil, 0
+		setErr(&result, err)
+	}
+	return result
+}
+
+// savepoint runs fn inside a savepoint when db is a transaction
+func savepoint(ctx context.Context, db Batcher, fn func(Batcher) error) error {
+	tx, ok := db.(pgx.Tx)
+	if !ok {
+		return fn(db)
+	}
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(sp); err != nil {
+		// A failed rollback leaves the whole transaction aborted; say so
+		if rbErr := sp.Rollback(ctx); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("roll back savepoint: %w", rbErr))
+		}
+		return err
+	}
+	return sp.Commit(ctx)
+}
+
+func collect(rows pgx.Rows) ([][]any, int64, error) {
+	defer rows.Close()
+	var values [][]any
+	for rows.Next() {
+		v, err := rows.Values()
+		if err != nil {
+			return nil, 0, err
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return values, rows.CommandTag().RowsAffected(), nil
+}
+
+func setErr(r *ItemResult, err error) {
+	r.Err = err
+	r.PgErr = nil
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		r.PgErr = pgErr
+	}
+}
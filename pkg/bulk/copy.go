@@ -0,0 +1,200 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Copier is anything that can run COPY FROM: *database.Pool, pgx.Tx, *pgx.Conn
+type Copier interface {
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// Config tunes a Loader
+type Config struct {
+	Columns     []string       // subset of the tagged columns to load (default all)
+	ChunkSize   int            // rows per COPY
+	Parallelism int            // chunks copied at once, each on its own pool connection
+	Progress    func(Progress) // called after each chunk, one call at a time
+}
+
+// DefaultConfig returns sensible defaults
+func DefaultConfig() Config {
+	return Config{ChunkSize: 5000, Parallelism: 1}
+}
+
+// Progress reports one finished chunk
+type Progress struct {
+	Chunk    int           // 0-based; with Parallelism > 1 chunks can finish out of order
+	Rows     int64         // rows in this chunk
+	Duration time.Duration // time spent copying this chunk
+	Total    int64         // rows copied so far
+	Elapsed  time.Duration // since the load started
+}
+
+// RowsPerSec is the throughput of the load so far
+func (p Progress) RowsPerSec() float64 { return rate(p.Total, p.Elapsed) }
+
+// Result summarizes a load
+type Result struct {
+	Rows    int64
+	Chunks  int
+	Elapsed time.Duration
+}
+
+// RowsPerSec is the overall throughput
+func (r Result) RowsPerSec() float64 { return rate(r.Rows, r.Elapsed) }
+
+func rate(rows int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(rows) / d.Seconds()
+}
+
+// Loader streams structs into a table with COPY. Columns come from
+// `db:"column"` struct tags:
+//
+//	type Asset struct {
+//		SiteID string `db:"site_id"`
+//		Serial string `db:"serial_number"`
+//		Notes  string `db:"-"`
+//	}
+//
+// Each chunk is its own COPY, so a failure leaves earlier chunks in place
+// unless db is a transaction. At most about 2*Parallelism+1 chunks are held
+// in memory at once, however many rows come in.
+type Loader[T any] struct {
+	db     Copier
+	table  pgx.Identifier
+	fields *fields
+	cfg    Config
+}
+
+// NewLoader creates a loader for T into table (optionally schema-qualified)
+func NewLoader[T any](db Copier, table string, cfg Config) (*Loader[T], error) {
+	defaults := DefaultConfig()
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaults.ChunkSize
+	}
+	if cfg.Parallelism <= 0 {
+		cfg.Parallelism = defaults.Parallelism
+	}
+	if _, isTx := db.(pgx.Tx); isTx && cfg.Parallelism > 1 {
+		return nil, errors.New("bulk: parallel loads need a pool, not a transaction")
+	}
+
+	f, err := fieldsOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	if f, err = f.subset(cfg.Columns); err != nil {
+		return nil, err
+	}
+	return &Loader[T]{db: db, table: pgx.Identifier(strings.Split(table, ".")), fields: f, cfg: cfg}, nil
+}
+
+// Columns returns the columns being loaded, in COPY order
+func (l *Loader[T]) Columns() []string {
+	return slices.Clone(l.fields.columns)
+}
+
+// Load copies rows
+func (l *Loader[T]) Load(ctx context.Context, rows []T) (Result, error) {
+	return l.LoadSeq(ctx, slices.Values(rows))
+}
+
+type chunk struct {
+	n    int
+	rows [][]any
+}
+
+// LoadSeq copies rows as seq yields them, so the input never has to fit in
+// memory. It stops at the first failed chunk; Result counts what was copied.
+func (l *Loader[T]) LoadSeq(ctx context.Context, seq iter.Seq[T]) (Result, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	start := time.Now()
+	var (
+		mu     sync.Mutex
+		result Result
+		wg     sync.WaitGroup
+	)
+	chunks := make(chan chunk, l.cfg.Parallelism)
+	for range l.cfg.Parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				if ctx.Err() != nil {
+					continue // drain
+				}
+				chunkStart := time.Now()
+				n, err := l.db.CopyFrom(ctx, l.table, l.fields.columns, pgx.CopyFromRows(c.rows))
+				if err != nil {
+					cancel(fmt.Errorf("copy chunk %d: %w", c.n, err))
+					continue
+				}
+
+				mu.Lock()
+				result.Rows += n
+				result.Chunks++
+				if l.cfg.Progress != nil {
+					l.cfg.Progress(Progress{
+						Chunk:    c.n,
+						Rows:     n,
+						Duration: time.Since(chunkStart),
+						Total:    result.Rows,
+						Elapsed:  time.Since(start),
+					})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	send := func(c chunk) {
+		select {
+		case chunks <- c:
+		case <-ctx.Done():
+		}
+	}
+	n := 0
+	buf := make([][]any, 0, l.cfg.ChunkSize)
+	for row := range seq {
+		if ctx.Err() != nil {
+			break
+		}
+		values, err := l.fields.values(row)
+		if err != nil {
+			cancel(fmt.Errorf("row %d: %w", n*l.cfg.ChunkSize+len(buf), err))
+			break
+		}
+		buf = append(buf, values)
+		if len(buf) == l.cfg.ChunkSize {
+			send(chunk{n: n, rows: buf})
+			n++
+			buf = make([][]any, 0, l.cfg.ChunkSize)
+		}
+	}
+	if len(buf) > 0 && ctx.Err() == nil {
+		send(chunk{n: n, rows: buf})
+	}
+	close(chunks)
+	wg.Wait()
+
+	result.Elapsed = time.Since(start)
+	if err := context.Cause(ctx); err != nil {
+		return result, err
+	}
+	return result, nil
+}
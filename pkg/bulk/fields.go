@@ -0,0 +1,119 @@
+package bulk
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fields maps a struct type's db-tagged fields to column names
+type fields struct {
+	columns []string
+	index   [][]int // reflect field index per column
+}
+
+var fieldCache sync.Map // reflect.Type -> *fields
+
+// fieldsOf reads `db:"column"` tags from T, which must be a struct or a
+// pointer to one. Untagged fields and `db:"-"` are skipped; embedded
+// structs without a tag are flattened.
+func fieldsOf[T any]() (*fields, error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.(*fields), nil
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("bulk: %s is not a struct", t)
+	}
+
+	f := &fields{}
+	seen := make(map[string]bool)
+	var walk func(t reflect.Type, prefix []int) error
+	walk = func(t reflect.Type, prefix []int) error {
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			index := append(append([]int(nil), prefix...), i)
+			tag, _, _ := strings.Cut(sf.Tag.Get("db"), ",")
+			if tag == "-" {
+				continue
+			}
+			if tag == "" {
+				ft := sf.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && ft.Kind() == reflect.Struct {
+					if err := walk(ft, index); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if !sf.IsExported() {
+				return fmt.Errorf("bulk: %s.%s is tagged but unexported", t, sf.Name)
+			}
+			if seen[tag] {
+				return fmt.Errorf("bulk: column %q is tagged twice in %s", tag, t)
+			}
+			seen[tag] = true
+			f.columns = append(f.columns, tag)
+			f.index = append(f.index, index)
+		}
+		return nil
+	}
+	if err := walk(t, nil); err != nil {
+		return nil, err
+	}
+	if len(f.columns) == 0 {
+		return nil, fmt.Errorf("bulk: %s has no db-tagged fields", t)
+	}
+
+	cached, _ := fieldCache.LoadOrStore(t, f)
+	return cached.(*fields), nil
+}
+
+// subset keeps only the named columns, in that order
+func (f *fields) subset(columns []string) (*fields, error) {
+	if len(columns) == 0 {
+		return f, nil
+	}
+	pos := make(map[string]int, len(f.columns))
+	for i, c := range f.columns {
+		pos[c] = i
+	}
+	out := &fields{}
+	for _, c := range columns {
+		i, ok := pos[c]
+		if !ok {
+			return nil, fmt.Errorf("bulk: no field tagged db:%q", c)
+		}
+		out.columns = append(out.columns, c)
+		out.index = append(out.index, f.index[i])
+	}
+	return out, nil
+}
+
+// values returns row's column values in column order. A nil embedded
+// pointer reads as NULL for the columns under it.
+func (f *fields) values(row any) ([]any, error) {
+	v := reflect.ValueOf(row)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, fmt.Errorf("bulk: nil row")
+		}
+		v = v.Elem()
+	}
+	values := make([]any, len(f.index))
+	for i, index := range f.index {
+		fv, err := v.FieldByIndexErr(index)
+		if err != nil {
+			continue // nil embedded pointer
+		}
+		values[i] = fv.Interface()
+	}
+	return values, nil
+}
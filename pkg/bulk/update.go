@@ -0,0 +1,211 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Queryer runs a query: *database.Pool, pgx.Tx, *pgx.Conn
+type Queryer interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// UpdateConfig describes a bulk update
+type UpdateConfig struct {
+	Key       string            // column matching rows to table rows (required)
+	Columns   []string          // columns to set (default all tagged columns except Key)
+	Set       map[string]string // per-column SET expression over t (the row) and u (the new values), e.g. "t.telemetry || u.telemetry"
+	Extra     string            // extra SET assignments, e.g. "updated_at = NOW()"
+	ChunkSize int               // rows per UPDATE
+}
+
+// UpdateResult reports a bulk update. NotFound holds the key of every row
+// that matched nothing in the table.
+type UpdateResult struct {
+	Updated  int64
+	NotFound []any
+	Elapsed  time.Duration
+}
+
+// Update applies rows to table with UPDATE ... FROM unnest(...), one
+// statement per chunk. Each column becomes one array parameter, cast to the
+// column's type from the catalog, so there is no SQL to build per row and
+// no limit on parameters:
+//
+//	UPDATE assets t SET status = u.status
+//	FROM unnest($1::uuid[], $2::varchar(50)[]) u(id, status)
+//	WHERE t.id = u.id
+//
+// If a key appears more than once, the last row wins. Chunks are separate
+// statements; pass a pgx.Tx as db to make the whole update atomic.
+func Update[T any](ctx context.Context, db Queryer, table string, rows []T, cfg UpdateConfig) (UpdateResult, error) {
+	start := time.Now()
+	if cfg.Key == "" {
+		return UpdateResult{}, errors.New("bulk: update needs a key column")
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 1000
+	}
+
+	all, err := fieldsOf[T]()
+	if err != nil {
+		return UpdateResult{}, err
+	}
+	columns := cfg.Columns
+	if len(columns) == 0 {
+		for _, c := range all.columns {
+			if c != cfg.Key {
+				columns = append(columns, c)
+			}
+		}
+	}
+	if len(columns) == 0 && cfg.Extra == "" {
+		return UpdateResult{}, errors.New("bulk: nothing to update")
+	}
+	if slices.Contains(columns, cfg.Key) {
+		return UpdateResult{}, fmt.Errorf("bulk: key column %q can't also be updated", cfg.Key)
+	}
+	f, err := all.subset(append([]string{cfg.Key}, columns...))
+	if err != nil {
+		return UpdateResult{}, err
+	}
+
+	ident := pgx.Identifier(strings.Split(table, "."))
+	types, err := columnTypes(ctx, db, ident, f.columns)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+	sql := updateSQL(ident, f.columns, types, cfg)
+
+	var result UpdateResult
+	for offset := 0; offset < len(rows); offset += cfg.ChunkSize {
+		chunk := rows[offset:min(offset+cfg.ChunkSize, len(rows))]
+
+		// One array per column
+		arrays := make([][]any, len(f.columns))
+		for i := range arrays {
+			arrays[i] = make([]any, len(chunk))
+		}
+		for r, row := range chunk {
+			values, err := f.values(row)
+			if err != nil {
+				return result, fmt.Errorf("row %d: %w", offset+r, err)
+			}
+			for i, v := range values {
+				arrays[i][r] = v
+			}
+		}
+		args := make([]any, len(arrays))
+		for i := range arrays {
+			args[i] = arrays[i]
+		}
+
+		var updated int64
+		var missing []int64
+		res, err := db.Query(ctx, sql, args...)
+		if err == nil {
+			_, err = pgx.CollectOneRow(res, func(row pgx.CollectableRow) (struct{}, error) {
+				return struct{}{}, row.Scan(&updated, &missing)
+			})
+		}
+		if err != nil {
+			return result, fmt.Errorf("update rows %d-%d: %w", offset, offset+len(chunk)-1, err)
+		}
+		result.Updated += updated
+		for _, ord := range missing {
+			result.NotFound = append(result.NotFound, arrays[0][ord-1])
+		}
+	}
+	result.Elapsed = time.Since(start)
+	return result, nil
+}
+
+// columnTypes looks up each column's type, e.g. "character varying(100)",
+// so the arrays are cast to exactly what the table holds.
+func columnTypes(ctx context.Context, db Queryer, table pgx.Identifier, columns []string) ([]string, error) {
+	rows, err := db.Query(ctx, `
+		SELECT attname, format_type(atttypid, atttypmod), attndims > 0 OR t.typcategory = 'A'
+		FROM pg_attribute a
+		JOIN pg_type t ON t.oid = a.atttypid
+		WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped
+	`, table.Sanitize())
+	if err != nil {
+		return nil, fmt.Errorf("look up column types: %w", err)
+	}
+	type column struct {
+		name, typ string
+		isArray   bool
+	}
+	found, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (column, error) {
+		var c column
+		err := row.Scan(&c.name, &c.typ, &c.isArray)
+		return c, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("look up column types: %w", err)
+	}
+
+	types := make([]string, len(columns))
+	for i, name := range columns {
+		j := slices.IndexFunc(found, func(c column) bool { return c.name == name })
+		if j < 0 {
+			return nil, fmt.Errorf("bulk: %s has no column %q", table.Sanitize(), name)
+		}
+		// unnest flattens nested arrays, so array columns can't ride along
+		if found[j].isArray {
+			return nil, fmt.Errorf("bulk: array column %q can't be updated with unnest", name)
+		}
+		types[i] = found[j].typ
+	}
+	return types, nil
+}
+
+// updateSQL builds the statement for one chunk, which returns the number of
+// rows updated and the 1-based positions of keys that matched nothing.
+func updateSQL(table pgx.Identifier, columns, types []string, cfg UpdateConfig) string {
+	cols := quoteAll(columns)
+	key := cols[0]
+
+	params := make([]string, len(columns))
+	for i, typ := range types {
+		params[i] = fmt.Sprintf("$%d::%s[]", i+1, typ)
+	}
+
+	var set []string
+	for i, c := range cols[1:] {
+		expr := "u." + c
+		if custom, ok := cfg.Set[columns[i+1]]; ok {
+			expr = custom
+		}
+		set = append(set, fmt.Sprintf("%s = %s", c, expr))
+	}
+	if cfg.Extra != "" {
+		set = append(set, cfg.Extra)
+	}
+
+	return fmt.Sprintf(`
+		WITH u AS (
+			SELECT DISTINCT ON (%[1]s) *
+			FROM unnest(%[2]s) WITH ORDINALITY AS u(%[3]s, bulk_ord)
+			ORDER BY %[1]s, bulk_ord DESC
+		), updated AS (
+			UPDATE %[4]s AS t SET %[5]s
+			FROM u
+			WHERE t.%[1]s = u.%[1]s
+			RETURNING t.%[1]s
+		)
+		SELECT
+			(SELECT COUNT(*) FROM updated),
+			ARRAY(
+				SELECT bulk_ord FROM u
+				WHERE NOT EXISTS (SELECT 1 FROM updated WHERE updated.%[1]s = u.%[1]s)
+				ORDER BY bulk_ord
+			)
+	`, key, strings.Join(params, ", "), strings.Join(cols, ", "), table.Sanitize(), strings.Join(set, ", "))
+}
@@ -0,0 +1,251 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// stagingTable is the temp table rows are COPYed into before the upsert
+const stagingTable = "bulk_upsert_staging"
+
+// stagingRow orders staged rows so the last duplicate of a key wins
+const stagingRow = "bulk_row"
+
+// Method is the statement used to apply staged rows
+type Method string
+
+const (
+	OnConflict Method = "on_conflict" // INSERT ... ON CONFLICT; needs a unique index on the conflict columns
+	Merge      Method = "merge"       // MERGE, PostgreSQL 15+
+)
+
+// UpsertConfig describes how staged rows are applied
+type UpsertConfig struct {
+	ConflictColumns []string // key identifying existing rows (required)
+	UpdateColumns   []string // columns overwritten on a match (default all loaded non-key columns)
+	DoNothing       bool     // only insert new rows; matches are skipped
+	SkipUnchanged   bool     // skip matches whose update columns already hold the same values
+	Method          Method
+	Load            Config // columns, chunk size and progress for the COPY into staging
+}
+
+// UpsertResult counts what happened to the staged rows. Skipped covers
+// matches left alone (DoNothing, SkipUnchanged) and duplicate keys within
+// the input, where the last row wins.
+type UpsertResult struct {
+	Staged   int64
+	Inserted int64
+	Updated  int64
+	Skipped  int64
+	Elapsed  time.Duration
+}
+
+// Upsert inserts or updates rows in table in one transaction. Rows are
+// COPYed into a temp staging table (ON COMMIT DROP) and applied with a
+// single INSERT ... ON CONFLICT or MERGE, which beats a batch of
+// single-row upserts by a wide margin.
+func Upsert[T any](ctx context.Context, pool *database.Pool, table string, rows iter.Seq[T], cfg UpsertConfig) (UpsertResult, error) {
+	var result UpsertResult
+	err := database.WithTx(ctx, pool, func(tx pgx.Tx) error {
+		var err error
+		result, err = UpsertTx(ctx, tx, table, rows, cfg)
+		return err
+	})
+	return result, err
+}
+
+// UpsertTx is Upsert inside the caller's transaction
+func UpsertTx[T any](ctx context.Context, tx pgx.Tx, table string, rows iter.Seq[T], cfg UpsertConfig) (UpsertResult, error) {
+	start := time.Now()
+	if len(cfg.ConflictColumns) == 0 {
+		return UpsertResult{}, errors.New("bulk: upsert needs conflict columns")
+	}
+	if cfg.Method == "" {
+		cfg.Method = OnConflict
+	}
+	cfg.Load.Parallelism = 1
+
+	loader, err := NewLoader[T](tx, stagingTable, cfg.Load)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	columns := loader.Columns()
+	for _, c := range cfg.ConflictColumns {
+		if !slices.Contains(columns, c) {
+			return UpsertResult{}, fmt.Errorf("bulk: conflict column %q is not loaded", c)
+		}
+	}
+	update := cfg.UpdateColumns
+	if len(update) == 0 {
+		for _, c := range columns {
+			if !slices.Contains(cfg.ConflictColumns, c) {
+				update = append(update, c)
+			}
+		}
+	}
+	for _, c := range update {
+		if !slices.Contains(columns, c) {
+			return UpsertResult{}, fmt.Errorf("bulk: update column %q is not loaded", c)
+		}
+	}
+	if len(update) == 0 {
+		cfg.DoNothing = true // nothing to update
+	}
+
+	target := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+	stage := pgx.Identifier{stagingTable}.Sanitize()
+	cols := quoteAll(columns)
+
+	// Same column types as the target, but none of its constraints,
+	// defaults or triggers
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+		DROP TABLE IF EXISTS %s;
+		CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA;
+		ALTER TABLE %s ADD COLUMN %s bigint GENERATED ALWAYS AS IDENTITY
+	`, stage, stage, strings.Join(cols, ", "), target, stage, stagingRow))
+	if err != nil {
+		return UpsertResult{}, fmt.Errorf("create staging table: %w", err)
+	}
+
+	loaded, err := loader.LoadSeq(ctx, rows)
+	if err != nil {
+		return UpsertResult{}, fmt.Errorf("stage rows: %w", err)
+	}
+	result := UpsertResult{Staged: loaded.Rows}
+
+	// One row per key; the last one staged wins
+	keys := strings.Join(quoteAll(cfg.ConflictColumns), ", ")
+	source := fmt.Sprintf("SELECT DISTINCT ON (%s) %s FROM %s ORDER BY %s, %s DESC",
+		keys, strings.Join(cols, ", "), stage, keys, stagingRow)
+
+	switch cfg.Method {
+	case OnConflict:
+		err = upsertOnConflict(ctx, tx, target, source, columns, update, cfg, &result)
+	case Merge:
+		err = upsertMerge(ctx, tx, target, source, columns, update, cfg, &result)
+	default:
+		err = fmt.Errorf("bulk: unknown upsert method %q", cfg.Method)
+	}
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	result.Skipped = result.Staged - result.Inserted - result.Updated
+	result.Elapsed = time.Since(start)
+	return result, nil
+}
+
+// upsertOnConflict tells inserts from updates by xmax: a freshly inserted
+// row version has none.
+func upsertOnConflict(ctx context.Context, tx pgx.Tx, target, source string, columns, update []string, cfg UpsertConfig, result *UpsertResult) error {
+	cols := strings.Join(quoteAll(columns), ", ")
+	action := "DO NOTHING"
+	if !cfg.DoNothing {
+		action = "DO UPDATE SET " + assignments(update, "EXCLUDED")
+		if cfg.SkipUnchanged {
+			action += " WHERE " + changed("t", "EXCLUDED", update)
+		}
+	}
+	err := tx.QueryRow(ctx, fmt.Sprintf(`
+		WITH upserted AS (
+			INSERT INTO %s AS t (%s)
+			SELECT %s FROM (%s) s
+			ON CONFLICT (%s) %s
+			RETURNING (xmax = 0) AS inserted
+		)
+		SELECT COUNT(*) FILTER (WHERE inserted), COUNT(*) FILTER (WHERE NOT inserted)
+		FROM upserted
+	`, target, cols, cols, source, strings.Join(quoteAll(cfg.ConflictColumns), ", "), action)).
+		Scan(&result.Inserted, &result.Updated)
+	if err != nil {
+		return fmt.Errorf("upsert: %w", err)
+	}
+	return nil
+}
+
+// upsertMerge counts matches first: MERGE only reports a total until PG17
+// adds RETURNING merge_action().
+func upsertMerge(ctx context.Context, tx pgx.Tx, target, source string, columns, update []string, cfg UpsertConfig, result *UpsertResult) error {
+	var version int
+	if err := tx.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version); err != nil {
+		return err
+	}
+	if version < 150000 {
+		return fmt.Errorf("bulk: MERGE needs PostgreSQL 15+, server is %d", version)
+	}
+
+	on := make([]string, len(cfg.ConflictColumns))
+	for i, c := range quoteAll(cfg.ConflictColumns) {
+		on[i] = fmt.Sprintf("t.%s = s.%s", c, c)
+	}
+	match := strings.Join(on, " AND ")
+
+	var unique, matched int64
+	err := tx.QueryRow(ctx, fmt.Sprintf(`
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM %s t WHERE %s))
+		FROM (%s) s
+	`, target, match, source)).Scan(&unique, &matched)
+	if err != nil {
+		return fmt.Errorf("count matches: %w", err)
+	}
+
+	cols := quoteAll(columns)
+	values := make([]string, len(cols))
+	for i, c := range cols {
+		values[i] = "s." + c
+	}
+	sql := fmt.Sprintf("MERGE INTO %s t USING (%s) s ON %s\n", target, source, match)
+	if !cfg.DoNothing {
+		when := "WHEN MATCHED"
+		if cfg.SkipUnchanged {
+			when += " AND " + changed("t", "s", update)
+		}
+		sql += fmt.Sprintf("%s THEN UPDATE SET %s\n", when, assignments(update, "s"))
+	}
+	sql += fmt.Sprintf("WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)",
+		strings.Join(cols, ", "), strings.Join(values, ", "))
+
+	tag, err := tx.Exec(ctx, sql)
+	if err != nil {
+		return fmt.Errorf("merge: %w", err)
+	}
+	result.Inserted = unique - matched
+	result.Updated = tag.RowsAffected() - result.Inserted
+	return nil
+}
+
+func quoteAll(columns []string) []string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pgx.Identifier{c}.Sanitize()
+	}
+	return quoted
+}
+
+// assignments renders "a = from.a, b = from.b"
+func assignments(columns []string, from string) string {
+	set := make([]string, len(columns))
+	for i, c := range quoteAll(columns) {
+		set[i] = fmt.Sprintf("%s = %s.%s", c, from, c)
+	}
+	return strings.Join(set, ", ")
+}
+
+// changed renders "(t.a, t.b) IS DISTINCT FROM (s.a, s.b)". Columns of
+// types without equality (json, point) can't be compared this way.
+func changed(current, incoming string, columns []string) string {
+	l := make([]string, len(columns))
+	r := make([]string, len(columns))
+	for i, c := range quoteAll(columns) {
+		l[i] = current + "." + c
+		r[i] = incoming + "." + c
+	}
+	return fmt.Sprintf("(%s) IS DISTINCT FROM (%s)", strings.Join(l, ", "), strings.Join(r, ", "))
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RoutingPolicy decides which healthy replica serves a read
+type RoutingPolicy int
+
+const (
+	// RoundRobin cycles through healthy replicas
+	RoundRobin RoutingPolicy = iota
+	// LeastConnections picks the replica with the fewest checked-out conns
+	LeastConnections
+)
+
+// ClusterConfig describes a primary and its streaming replicas.
+//
+// The replica role should be a member of pg_monitor (or a superuser) so the
+// health check can see whether the WAL receiver is streaming. Without it,
+// lag is the time since the last replayed commit: a replica cut off from
+// the primary is only caught once that passes MaxReplicationLag, and an idle
+// primary makes caught-up replicas look behind.
+type ClusterConfig struct {
+	Primary             *Config
+	Replicas            []*Config
+	Policy              RoutingPolicy
+	MaxReplicationLag   time.Duration // replicas further behind are skipped
+	HealthCheckInterval time.Duration
+}
+
+// DefaultClusterConfig returns sensible defaults around a primary config
+func DefaultClusterConfig(primary *Config, replicas ...*Config) ClusterConfig {
+	return ClusterConfig{
+		Primary:             primary,
+		Replicas:            replicas,
+		Policy:              RoundRobin,
+		MaxReplicationLag:   10 * time.Second,
+		HealthCheckInterval: 5 * time.Second,
+	}
+}
+
+// ReplicaStatus is the last health check result for one replica
+type ReplicaStatus struct {
+	Name      string
+	Healthy   bool
+	Lag       time.Duration
+	Err       error
+	CheckedAt time.Time
+}
+
+type replica struct {
+	name string
+	pool *Pool
+
+	mu     sync.RWMutex
+	status ReplicaStatus
+}
+
+// Cluster routes reads to healthy replicas and everything else to the primary.
+// Replicas that are unreachable or lag more than MaxReplicationLag are taken
+// out of rotation until a later health check passes; with no healthy
+// replicas, reads fall back to the primary.
+type Cluster struct {
+	primary  *Pool
+	replicas []*replica
+	cfg      ClusterConfig
+	next     atomic.Uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCluster connects to the primary and every replica and starts the
+// background lag checks. It fails if any node can't be reached. See
+// ClusterConfig for the privileges the checks need.
+func NewCluster(ctx context.Context, cfg ClusterConfig) (*Cluster, error) {
+	if cfg.Primary == nil {
+		return nil, errors.New("cluster: primary config is required")
+	}
+	if cfg.HealthCheckInterval <= 0 {
+		cfg.HealthCheckInterval = 5 * time.Second
+	}
+
+	primary, err := NewPool(ctx, cfg.Primary)
+	if err != nil {
+		return nil, fmt.Errorf("connect primary: %w", err)
+	}
+
+	c := &Cluster{primary: primary, cfg: cfg, done: make(chan struct{})}
+	for i, rc := range cfg.Replicas {
+		pool, err := NewPool(ctx, rc)
+		if err != nil {
+			c.closePools()
+			return nil, fmt.Errorf("connect replica %d (%s): %w", i, rc.Host, err)
+		}
+		c.replicas = append(c.replicas, &replica{name: fmt.Sprintf("replica-%d", i), pool: pool})
+	}
+
+	c.checkReplicas(ctx)
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go c.healthLoop(loopCtx)
+
+	return c, nil
+}
+
+// Primary returns the primary pool; use it for writes and read-your-writes
+func (c *Cluster) Primary() *Pool {
+	return c.primary
+}
+
+// Reader returns a pool for read-only queries: a healthy replica chosen by
+// the routing policy, or the primary if none are usable.
+func (c *Cluster) Reader() *Pool {
+	healthy := make([]*replica, 0, len(c.replicas))
+	for _, r := range c.replicas {
+		r.mu.RLock()
+		ok := r.status.Healthy
+		r.mu.RUnlock()
+		if ok {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return c.primary
+	}
+
+	switch c.cfg.Policy {
+	case LeastConnections:
+		best := healthy[0]
+		for _, r := range healthy[1:] {
+			if r.pool.Stat().AcquiredConns() < best.pool.Stat().AcquiredConns() {
+				best = r
+			}
+		}
+		return best.pool
+	default:
+		n := c.next.Add(1)
+		return healthy[n%uint64(len(healthy))].pool
+	}
+}
+
+// Replicas reports the most recent health check for each replica
+func (c *Cluster) Replicas() []ReplicaStatus {
+	out := make([]ReplicaStatus, len(c.replicas))
+	for i, r := range c.replicas {
+		r.mu.RLock()
+		out[i] = r.status
+		r.mu.RUnlock()
+	}
+	return out
+}
+
+// Stats returns pool statistics for every node, keyed for WritePrometheus
+func (c *Cluster) Stats() map[string]PoolStats {
+	stats := map[string]PoolStats{"primary": c.primary.Stats()}
+	for _, r := range c.replicas {
+		stats[r.name] = r.pool.Stats()
+	}
+	return stats
+}
+
+// Close stops health checks and closes every pool
+func (c *Cluster) Close() {
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+	}
+	c.closePools()
+}
+
+func (c *Cluster) closePools() {
+	c.primary.Close()
+	for _, r := range c.replicas {
+		r.pool.Close()
+	}
+}
+
+func (c *Cluster) healthLoop(ctx context.Context) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkReplicas(ctx)
+		}
+	}
+}
+
+func (c *Cluster) checkReplicas(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range c.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			status := c.checkReplica(ctx, r)
+			r.mu.Lock()
+			r.status = status
+			r.mu.Unlock()
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (c *Cluster) checkReplica(ctx context.Context, r *replica) ReplicaStatus {
+	status := ReplicaStatus{Name: r.name, CheckedAt: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.HealthCheckInterval)
+	defer cancel()
+
+	state, err := QueryReplicationState(ctx, r.pool)
+	if err != nil {
+		// Includes ErrNotStreaming: a replica cut off from the primary
+		// looks caught up forever, so it must leave the rotation
+		status.Err = err
+		return status
+	}
+	if !state.InRecovery {
+		// Promoted or misconfigured; its data may have diverged from the primary
+		status.Err = errors.New("not in recovery")
+		return status
+	}
+	if state.Lag == nil {
+		status.Err = errors.New("no transactions replayed yet")
+		return status
+	}
+
+	status.Lag = *state.Lag
+	if c.cfg.MaxReplicationLag > 0 && status.Lag > c.cfg.MaxReplicationLag {
+		status.Err = fmt.Errorf("replication lag %v exceeds %v", status.Lag, c.cfg.MaxReplicationLag)
+		return status
+	}
+
+	status.Healthy = true
+	return status
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lazyPool returns a pool that never connects unless something acquires from it
+func lazyPool(t *testing.T) *Pool {
+	t.Helper()
+	p, err := pgxpool.New(context.Background(), "postgres://nobody@127.0.0.1:1/none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	return &Pool{Pool: p}
+}
+
+func testCluster(t *testing.T, policy RoutingPolicy, healthy ...bool) *Cluster {
+	t.Helper()
+	c := &Cluster{primary: lazyPool(t), cfg: ClusterConfig{Policy: policy}}
+	for i, ok := range healthy {
+		r := &replica{name: fmt.Sprintf("replica-%d", i), pool: lazyPool(t)}
+		r.status.Healthy = ok
+		c.replicas = append(c.replicas, r)
+	}
+	return c
+}
+
+func TestReaderRoundRobinSkipsUnhealthy(t *testing.T) {
+	c := testCluster(t, RoundRobin, true, false, true)
+
+	seen := map[*Pool]int{}
+	for i := 0; i < 10; i++ {
+		seen[c.Reader()]++
+	}
+	if seen[c.replicas[0].pool] != 5 || seen[c.replicas[2].pool] != 5 {
+		t.Errorf("reads should alternate between the healthy replicas, got %d/%d",
+			seen[c.replicas[0].pool], seen[c.replicas[2].pool])
+	}
+	if seen[c.replicas[1].pool] != 0 || seen[c.primary] != 0 {
+		t.Error("unhealthy replica or primary served a read while healthy replicas exist")
+	}
+}
+
+func TestReaderFallsBackToPrimary(t *testing.T) {
+	for _, policy := range []RoutingPolicy{RoundRobin, LeastConnections} {
+		c := testCluster(t, policy, false, false)
+		if c.Reader() != c.primary {
+			t.Errorf("policy %d: reads should fall back to the primary with no healthy replicas", policy)
+		}
+		c = testCluster(t, policy)
+		if c.Reader() != c.primary {
+			t.Errorf("policy %d: reads should go to the primary without replicas", policy)
+		}
+	}
+}
+
+func TestReaderLeastConnectionsSkipsUnhealthy(t *testing.T) {
+	c := testCluster(t, LeastConnections, false, true, true)
+	// All idle: the first healthy replica wins the tie
+	if c.Reader() != c.replicas[1].pool {
+		t.Error("least-connections picked an unhealthy replica or broke the tie oddly")
+	}
+}
+
+// TestClusterLive needs a primary and a streaming replica (make up-replica)
+// whose role can see pg_stat_wal_receiver, e.g. the compose superuser.
+func TestClusterLive(t *testing.T) {
+	primary := liveConfig(t, "PLAYGROUND_TEST_DATABASE_URL")
+	replicaCfg := liveConfig(t, "PLAYGROUND_TEST_REPLICA_URL")
+	other := *replicaCfg
+
+	ctx := context.Background()
+	cfg := DefaultClusterConfig(primary, replicaCfg, &other)
+	cfg.Policy = LeastConnections
+	cluster, err := NewCluster(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	for _, s := range cluster.Replicas() {
+		if !s.Healthy {
+			t.Fatalf("%s unhealthy: %v", s.Name, s.Err)
+		}
+	}
+
+	// Both "replicas" are the same server; holding a conn on the first
+	// should send reads to the second
+	conn, err := cluster.replicas[0].pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Reader() != cluster.replicas[1].pool {
+		t.Error("least-connections should avoid the replica with a checked-out conn")
+	}
+	conn.Release()
+
+	var inRecovery bool
+	if err := cluster.Reader().QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		t.Fatal(err)
+	}
+	if !inRecovery {
+		t.Error("Reader() returned a pool that isn't connected to a standby")
+	}
+}
+
+// TestReplicationStateDisconnected cuts the replica's WAL receiver and checks
+// that the replica stops reporting zero lag. Postgres restarts the receiver
+// after wal_retrieve_retry_interval (5s by default), so the window is short.
+func TestReplicationStateDisconnected(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool(ctx, liveConfig(t, "PLAYGROUND_TEST_REPLICA_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if _, err := QueryReplicationState(ctx, pool); err != nil {
+		t.Fatalf("replica should be streaming before the test: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "SELECT pg_terminate_backend(pid) FROM pg_stat_wal_receiver"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		state, err := QueryReplicationState(ctx, pool)
+		if errors.Is(err, ErrNotStreaming) {
+			if state.Lag != nil {
+				t.Errorf("lag %v reported for a disconnected replica", *state.Lag)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("replica kept streaming after its WAL receiver was terminated")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
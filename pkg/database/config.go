@@ -0,0 +1,455 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jackc/pgpassfile"
+	"github.com/jackc/pgservicefile"
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the environment variable holding a config file path
+const ConfigFileEnv = "DATABASE_CONFIG"
+
+// LoadConfig builds a Config from every source we know about and validates it.
+//
+// Like libpq, the more explicit a source, the more it wins. Sources are
+// applied in order, each one overriding the previous:
+//
+//  1. DefaultConfig
+//  2. PGHOST, PGPORT, PGDATABASE, PGUSER, PGPASSWORD and the PGSSL* variables
+//  3. pg_service.conf entry named by the file's service key, else $PGSERVICE
+//  4. YAML or TOML file at path (or $DATABASE_CONFIG when path is empty)
+//  5. DATABASE_URL
+//
+// If none of those set a password, ~/.pgpass (or $PGPASSFILE) is consulted.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	passwordSet := false
+
+	var fc *fileConfig
+	if path == "" {
+		path = os.Getenv(ConfigFileEnv)
+	}
+	if path != "" {
+		var err error
+		if fc, err = readConfigFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	env := map[string]string{}
+	for key, envVar := range pgEnvVars {
+		if v := os.Getenv(envVar); v != "" {
+			env[key] = v
+		}
+	}
+	if env["password"] != "" {
+		passwordSet = true
+	}
+	if err := cfg.applySettings(env); err != nil {
+		return nil, fmt.Errorf("environment: %w", err)
+	}
+
+	cfg.Service = os.Getenv("PGSERVICE")
+	if fc != nil && fc.Service != "" {
+		cfg.Service = fc.Service
+	}
+	if cfg.Service != "" {
+		settings, err := lookupService(cfg.Service)
+		if err != nil {
+			return nil, err
+		}
+		if settings["password"] != "" {
+			passwordSet = true
+		}
+		if err := cfg.applySettings(settings); err != nil {
+			return nil, fmt.Errorf("service %q: %w", cfg.Service, err)
+		}
+	}
+
+	if fc != nil {
+		if fc.Password != "" {
+			passwordSet = true
+		}
+		if err := fc.apply(cfg); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		settings, err := parseURL(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("DATABASE_URL: %w", err)
+		}
+		if settings["password"] != "" {
+			passwordSet = true
+		}
+		if err := cfg.applySettings(settings); err != nil {
+			return nil, fmt.Errorf("DATABASE_URL: %w", err)
+		}
+	}
+
+	if !passwordSet {
+		if pw := lookupPgpass(cfg); pw != "" {
+			cfg.Password = pw
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that the config can be used to open a pool
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Host == "" {
+		errs = append(errs, errors.New("host is required"))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d out of range", c.Port))
+	}
+	if c.Database == "" {
+		errs = append(errs, errors.New("database is required"))
+	}
+	if c.User == "" {
+		errs = append(errs, errors.New("user is required"))
+	}
+	switch c.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("unknown sslmode %q", c.SSLMode))
+	}
+	if (c.SSLCert == "") != (c.SSLKey == "") {
+		errs = append(errs, errors.New("sslcert and sslkey must be set together"))
+	}
+	if c.SSLMode == "disable" && (c.SSLRootCert != "" || c.SSLCert != "") {
+		errs = append(errs, errors.New("certificates are configured but sslmode is disable"))
+	}
+	if c.MaxConns < 1 {
+		errs = append(errs, fmt.Errorf("max_conns must be positive, got %d", c.MaxConns))
+	}
+	if c.MinConns < 0 || c.MinConns > c.MaxConns {
+		errs = append(errs, fmt.Errorf("min_conns %d must be between 0 and max_conns %d", c.MinConns, c.MaxConns))
+	}
+	if c.MaxConnLifetime < 0 || c.MaxConnIdleTime < 0 {
+		errs = append(errs, errors.New("connection lifetimes must not be negative"))
+	}
+	if c.SlowQueryThreshold < 0 {
+		errs = append(errs, errors.New("slow_query_threshold must not be negative"))
+	}
+	if c.StatementTimeout < 0 || c.LockTimeout < 0 || c.IdleInTransactionSessionTimeout < 0 {
+		errs = append(errs, errors.New("session timeouts must not be negative"))
+	}
+	if _, err := c.queryExecMode(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid database config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// ConnString renders the config as a postgres:// URL. A Host starting with
+// "/" is a unix socket directory and goes in the host query parameter.
+func (c *Config) ConnString() string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(c.User, c.Password),
+		Path:   "/" + c.Database,
+	}
+	q := url.Values{}
+	if isSocketDir(c.Host) {
+		q.Set("host", c.Host)
+		q.Set("port", strconv.Itoa(c.Port))
+	} else {
+		u.Host = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	}
+	q.Set("sslmode", c.SSLMode)
+	if c.SSLRootCert != "" {
+		q.Set("sslrootcert", c.SSLRootCert)
+	}
+	if c.SSLCert != "" {
+		q.Set("sslcert", c.SSLCert)
+		q.Set("sslkey", c.SSLKey)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// ConnConfig parses the config for a single pgx connection, with the same
+// certificate reloading NewPool uses. Handy for stdlib.OpenDB.
+func (c *Config) ConnConfig() (*pgx.ConnConfig, error) {
+	connConfig, err := pgx.ParseConfig(c.ConnString())
+	if err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	if err := c.configureTLS(&connConfig.Config); err != nil {
+		return nil, fmt.Errorf("configure tls: %w", err)
+	}
+	if err := c.configureExecMode(connConfig); err != nil {
+		return nil, fmt.Errorf("configure exec mode: %w", err)
+	}
+	return connConfig, nil
+}
+
+// fileConfig is the on-disk shape of Config; zero values and nil pointers mean "not set"
+type fileConfig struct {
+	Host            string         `yaml:"host" toml:"host"`
+	Port            int            `yaml:"port" toml:"port"`
+	Database        string         `yaml:"database" toml:"database"`
+	User            string         `yaml:"user" toml:"user"`
+	Password        string         `yaml:"password" toml:"password"`
+	SSLMode         string         `yaml:"sslmode" toml:"sslmode"`
+	SSLRootCert     string         `yaml:"sslrootcert" toml:"sslrootcert"`
+	SSLCert         string         `yaml:"sslcert" toml:"sslcert"`
+	SSLKey          string         `yaml:"sslkey" toml:"sslkey"`
+	SSLServerName   string         `yaml:"sslservername" toml:"sslservername"`
+	Service         string         `yaml:"service" toml:"service"`
+	MaxConns        *int32         `yaml:"max_conns" toml:"max_conns"`
+	MinConns        *int32         `yaml:"min_conns" toml:"min_conns"`
+	MaxConnLifetime *time.Duration `yaml:"max_conn_lifetime" toml:"max_conn_lifetime"`
+	MaxConnIdleTime *time.Duration `yaml:"max_conn_idle_time" toml:"max_conn_idle_time"`
+
+	SlowQueryThreshold *time.Duration `yaml:"slow_query_threshold" toml:"slow_query_threshold"`
+
+	ApplicationName                 string            `yaml:"application_name" toml:"application_name"`
+	SearchPath                      string            `yaml:"search_path" toml:"search_path"`
+	StatementTimeout                *time.Duration    `yaml:"statement_timeout" toml:"statement_timeout"`
+	LockTimeout                     *time.Duration    `yaml:"lock_timeout" toml:"lock_timeout"`
+	IdleInTransactionSessionTimeout *time.Duration    `yaml:"idle_in_transaction_session_timeout" toml:"idle_in_transaction_session_timeout"`
+	Settings                        map[string]string `yaml:"settings" toml:"settings"`
+
+	PgBouncer *bool  `yaml:"pgbouncer" toml:"pgbouncer"`
+	ExecMode  string `yaml:"exec_mode" toml:"exec_mode"`
+}
+
+func readConfigFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	fc := &fileConfig{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, fc)
+	case ".toml":
+		err = toml.Unmarshal(data, fc)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension (want .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return fc, nil
+}
+
+func (fc *fileConfig) apply(cfg *Config) error {
+	settings := map[string]string{
+		"host":             fc.Host,
+		"dbname":           fc.Database,
+		"user":             fc.User,
+		"password":         fc.Password,
+		"sslmode":          fc.SSLMode,
+		"sslrootcert":      fc.SSLRootCert,
+		"sslcert":          fc.SSLCert,
+		"sslkey":           fc.SSLKey,
+		"sslservername":    fc.SSLServerName,
+		"application_name": fc.ApplicationName,
+	}
+	if fc.Port != 0 {
+		settings["port"] = strconv.Itoa(fc.Port)
+	}
+	if err := cfg.applySettings(settings); err != nil {
+		return err
+	}
+
+	if fc.MaxConns != nil {
+		cfg.MaxConns = *fc.MaxConns
+	}
+	if fc.MinConns != nil {
+		cfg.MinConns = *fc.MinConns
+	}
+	if fc.MaxConnLifetime != nil {
+		cfg.MaxConnLifetime = *fc.MaxConnLifetime
+	}
+	if fc.MaxConnIdleTime != nil {
+		cfg.MaxConnIdleTime = *fc.MaxConnIdleTime
+	}
+	if fc.SlowQueryThreshold != nil {
+		cfg.SlowQueryThreshold = *fc.SlowQueryThreshold
+	}
+
+	if fc.SearchPath != "" {
+		cfg.SearchPath = fc.SearchPath
+	}
+	if fc.StatementTimeout != nil {
+		cfg.StatementTimeout = *fc.StatementTimeout
+	}
+	if fc.LockTimeout != nil {
+		cfg.LockTimeout = *fc.LockTimeout
+	}
+	if fc.IdleInTransactionSessionTimeout != nil {
+		cfg.IdleInTransactionSessionTimeout = *fc.IdleInTransactionSessionTimeout
+	}
+	if fc.PgBouncer != nil {
+		cfg.PgBouncer = *fc.PgBouncer
+	}
+	if fc.ExecMode != "" {
+		cfg.ExecMode = fc.ExecMode
+	}
+	if len(fc.Settings) > 0 {
+		if cfg.Settings == nil {
+			cfg.Settings = make(map[string]string, len(fc.Settings))
+		}
+		for k, v := range fc.Settings {
+			cfg.Settings[k] = v
+		}
+	}
+	return nil
+}
+
+// pgEnvVars maps libpq keywords to their environment variables
+var pgEnvVars = map[string]string{
+	"host":             "PGHOST",
+	"port":             "PGPORT",
+	"dbname":           "PGDATABASE",
+	"user":             "PGUSER",
+	"password":         "PGPASSWORD",
+	"sslmode":          "PGSSLMODE",
+	"sslrootcert":      "PGSSLROOTCERT",
+	"sslcert":          "PGSSLCERT",
+	"sslkey":           "PGSSLKEY",
+	"sslservername":    "PGSSLSERVERNAME",
+	"application_name": "PGAPPNAME",
+}
+
+// applySettings overrides config fields from libpq-style keywords, skipping empty values
+func (c *Config) applySettings(settings map[string]string) error {
+	for key, value := range settings {
+		if value == "" {
+			continue
+		}
+		switch key {
+		case "host":
+			c.Host = value
+		case "port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid port %q", value)
+			}
+			c.Port = port
+		case "dbname":
+			c.Database = value
+		case "user":
+			c.User = value
+		case "password":
+			c.Password = value
+		case "sslmode":
+			c.SSLMode = value
+		case "sslrootcert":
+			c.SSLRootCert = value
+		case "sslcert":
+			c.SSLCert = value
+		case "sslkey":
+			c.SSLKey = value
+		case "sslservername":
+			c.SSLServerName = value
+		case "application_name":
+			c.ApplicationName = value
+		}
+	}
+	return nil
+}
+
+// parseURL turns a postgres:// URL into libpq-style keywords
+func parseURL(dsn string) (map[string]string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	settings := map[string]string{
+		"host":   u.Hostname(),
+		"port":   u.Port(),
+		"dbname": strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		settings["user"] = u.User.Username()
+		settings["password"], _ = u.User.Password()
+	}
+	for key, values := range u.Query() {
+		if len(values) > 0 {
+			settings[key] = values[len(values)-1]
+		}
+	}
+	return settings, nil
+}
+
+func lookupService(name string) (map[string]string, error) {
+	path := os.Getenv("PGSERVICEFILE")
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".pg_service.conf")
+		}
+		if _, err := os.Stat(path); err != nil {
+			if dir := os.Getenv("PGSYSCONFDIR"); dir != "" {
+				path = filepath.Join(dir, "pg_service.conf")
+			}
+		}
+	}
+
+	sf, err := pgservicefile.ReadServicefile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read service file: %w", err)
+	}
+	service, err := sf.GetService(name)
+	if err != nil {
+		return nil, fmt.Errorf("service %q in %s: %w", name, path, err)
+	}
+	return service.Settings, nil
+}
+
+func lookupPgpass(cfg *Config) string {
+	path := os.Getenv("PGPASSFILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		path = filepath.Join(home, ".pgpass")
+	}
+
+	pf, err := pgpassfile.ReadPassfile(path)
+	if err != nil {
+		return ""
+	}
+	host := cfg.Host
+	if isSocketDir(host) {
+		// libpq matches socket connections against "localhost"
+		host = "localhost"
+	}
+	return pf.FindPassword(host, strconv.Itoa(cfg.Port), cfg.Database, cfg.User)
+}
+
+// isSocketDir reports whether host names a unix socket directory, as libpq
+// decides it
+func isSocketDir(host string) bool {
+	return strings.HasPrefix(host, "/")
+}
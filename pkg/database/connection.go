@@ -16,10 +16,31 @@ type Config struct {
 	Database        string
 	User            string
 	Password        string
+	SSLMode         string // disable, allow, prefer, require, verify-ca, verify-full
+	SSLRootCert     string // CA bundle; empty means system roots
+	SSLCert         string // client certificate
+	SSLKey          string // client private key
+	SSLServerName   string // SNI and verify-full hostname; defaults to Host
+	Service         string // pg_service.conf entry, resolved by LoadConfig
 	MaxConns        int32
 	MinConns        int32
 	MaxConnLifetime time.Duration
 	MaxConnIdleTime time.Duration
+
+	SlowQueryThreshold time.Duration // log statements slower than this; 0 disables
+
+	// Session parameters set on every new connection; zero values keep the server default
+	ApplicationName                 string
+	SearchPath                      string
+	StatementTimeout                time.Duration
+	LockTimeout                     time.Duration
+	IdleInTransactionSessionTimeout time.Duration
+	Settings                        map[string]string // any other GUCs, e.g. "work_mem": "64MB"
+
+	// PgBouncer marks a transaction-pooling PgBouncer in front of the server:
+	// no statement caching, no session settings, no session-level helpers.
+	PgBouncer bool
+	ExecMode  string // cache_statement, cache_describe, describe_exec, exec or simple_protocol
 }
 
 // DefaultConfig returns sensible defaults
@@ -30,6 +51,7 @@ func DefaultConfig() *Config {
 		Database:        "playground",
 		User:            "grug",
 		Password:        "grug_like_simple",
+		SSLMode:         "disable",
 		MaxConns:        25,
 		MinConns:        5,
 		MaxConnLifetime: time.Hour,
@@ -40,20 +62,23 @@ func DefaultConfig() *Config {
 // Pool wraps pgxpool for cleaner access
 type Pool struct {
 	*pgxpool.Pool
-	config *Config
+	config   *Config
+	counters *poolCounters
+	tracer   *QueryTracer
 }
 
 // NewPool creates a connection pool
 func NewPool(ctx context.Context, cfg *Config) (*Pool, error) {
-	dsn := fmt.Sprintf(
-		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
-		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Database,
-	)
-
-	poolConfig, err := pgxpool.ParseConfig(dsn)
+	poolConfig, err := pgxpool.ParseConfig(cfg.ConnString())
 	if err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
+	if err := cfg.configureTLS(&poolConfig.ConnConfig.Config); err != nil {
+		return nil, fmt.Errorf("configure tls: %w", err)
+	}
+	if err := cfg.configureExecMode(poolConfig.ConnConfig); err != nil {
+		return nil, fmt.Errorf("configure exec mode: %w", err)
+	}
 
 	// Pool configuration
 	poolConfig.MaxConns = cfg.MaxConns
@@ -61,17 +86,26 @@ func NewPool(ctx context.Context, cfg *Config) (*Pool, error) {
 	poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
 	poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
 
+	// Query tracing: slow query log + per-statement histograms
+	tracer := NewQueryTracer(nil, cfg.SlowQueryThreshold)
+	poolConfig.ConnConfig.Tracer = tracer
+
 	// Connection lifecycle
+	poolConfig.AfterConnect = cfg.afterConnect()
+
+	counters := &poolCounters{}
 	poolConfig.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
-		// Could add logging/metrics here
+		counters.acquired(conn)
 		return true
 	}
 
 	poolConfig.AfterRelease = func(conn *pgx.Conn) bool {
-		// Could add logging/metrics here
+		counters.released(conn)
 		return true
 	}
 
+	poolConfig.BeforeClose = counters.closed
+
 	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, fmt.Errorf("create pool: %w", err)
@@ -83,16 +117,17 @@ func NewPool(ctx context.Context, cfg *Config) (*Pool, error) {
 		return nil, fmt.Errorf("ping database: %w", err)
 	}
 
-	return &Pool{Pool: pool, config: cfg}, nil
+	return &Pool{Pool: pool, config: cfg, counters: counters, tracer: tracer}, nil
+}
+
+// Config returns the config the pool was created from
+func (p *Pool) Config() *Config {
+	return p.config
 }
 
-// Stats returns pool statistics
-func (p *Pool) Stats() string {
-	stats := p.Pool.Stat()
-	return fmt.Sprintf(
-		"Pool Stats - Total: %d, Idle: %d, InUse: %d, MaxConns: %d",
-		stats.TotalConns(), stats.IdleConns(), stats.AcquiredConns(), stats.MaxConns(),
-	)
+// Tracer returns the query tracer attached to every pooled connection
+func (p *Pool) Tracer() *QueryTracer {
+	return p.tracer
 }
 
 // HealthCheck verifies database connectivity
@@ -112,31 +147,3 @@ func (p *Pool) HealthCheck(ctx context.Context) error {
 
 	return nil
 }
-
-// WithTx runs a function within a transaction
-func WithTx(ctx context.Context, pool *Pool, fn func(pgx.Tx) error) error {
-	tx, err := pool.Begin(ctx)
-	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
-	}
-
-	defer func() {
-		if p := recover(); p != nil {
-			_ = tx.Rollback(ctx)
-			panic(p) // re-throw
-		}
-	}()
-
-	if err := fn(tx); err != nil {
-		if rbErr := tx.Rollback(ctx); rbErr != nil {
-			return fmt.Errorf("rollback failed: %v, original error: %w", rbErr, err)
-		}
-		return err
-	}
-
-	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("commit failed: %w", err)
-	}
-
-	return nil
-}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrSessionUnavailable is returned by helpers that need session state
+// (LISTEN, advisory locks, prepared statements) when the pool sits behind
+// PgBouncer in transaction mode, where each transaction may land on a
+// different server connection.
+var ErrSessionUnavailable = errors.New("session-level features are unavailable behind PgBouncer transaction pooling")
+
+var execModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// queryExecMode resolves ExecMode, defaulting to simple_protocol behind
+// PgBouncer and pgx's cache_statement otherwise.
+func (c *Config) queryExecMode() (pgx.QueryExecMode, error) {
+	name := c.ExecMode
+	if name == "" {
+		if !c.PgBouncer {
+			return pgx.QueryExecModeCacheStatement, nil
+		}
+		name = "simple_protocol"
+	}
+
+	mode, ok := execModes[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown exec_mode %q", name)
+	}
+	if c.PgBouncer && (mode == pgx.QueryExecModeCacheStatement || mode == pgx.QueryExecModeCacheDescribe) {
+		return 0, fmt.Errorf("exec_mode %q caches per-connection state and breaks behind PgBouncer; use simple_protocol or describe_exec", name)
+	}
+	return mode, nil
+}
+
+// configureExecMode sets the query protocol. Behind PgBouncer, session
+// settings can't be SET on connect (they would leak to whichever client gets
+// the server connection next), so only application_name is sent, as a
+// startup parameter PgBouncer tracks per client.
+func (c *Config) configureExecMode(cc *pgx.ConnConfig) error {
+	mode, err := c.queryExecMode()
+	if err != nil {
+		return err
+	}
+	cc.DefaultQueryExecMode = mode
+
+	if c.PgBouncer && c.ApplicationName != "" {
+		cc.RuntimeParams["application_name"] = c.ApplicationName
+	}
+	return nil
+}
+
+// afterConnect returns the AfterConnect hook, or nil behind PgBouncer
+func (c *Config) afterConnect() func(context.Context, *pgx.Conn) error {
+	if !c.PgBouncer {
+		return c.ApplySession
+	}
+	if c.StatementTimeout > 0 || c.LockTimeout > 0 || c.IdleInTransactionSessionTimeout > 0 || c.SearchPath != "" || len(c.Settings) > 0 {
+		slog.Warn("session settings are not applied behind PgBouncer; set them on the role instead (ALTER ROLE ... SET)")
+	}
+	return nil
+}
+
+// RequireSession returns ErrSessionUnavailable, naming feature, when the pool
+// is behind PgBouncer in transaction mode.
+func (p *Pool) RequireSession(feature string) error {
+	if p.config != nil && p.config.PgBouncer {
+		return fmt.Errorf("%s: %w", feature, ErrSessionUnavailable)
+	}
+	return nil
+}
+
+// AcquireSession pins a connection for session-level work such as LISTEN or
+// advisory locks. It refuses behind PgBouncer instead of silently losing the
+// session state between transactions.
+func (p *Pool) AcquireSession(ctx context.Context, feature string) (*pgxpool.Conn, error) {
+	if err := p.RequireSession(feature); err != nil {
+		return nil, err
+	}
+	return p.Acquire(ctx)
+}
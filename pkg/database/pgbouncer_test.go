@@ -0,0 +1,148 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/lock"
+	"roguh.com/postgres_playground/pkg/notify"
+)
+
+// pgbouncerConfig points at a transaction-pooling PgBouncer (make
+// up-pgbouncer), skipping the test unless PLAYGROUND_TEST_PGBOUNCER_URL is set
+func pgbouncerConfig(t *testing.T, execMode string) *database.Config {
+	t.Helper()
+	dsn := os.Getenv("PLAYGROUND_TEST_PGBOUNCER_URL")
+	if dsn == "" {
+		t.Skip("set PLAYGROUND_TEST_PGBOUNCER_URL to run against PgBouncer")
+	}
+	t.Setenv(database.ConfigFileEnv, "")
+	t.Setenv("DATABASE_URL", dsn)
+	cfg, err := database.LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PgBouncer = true
+	cfg.ExecMode = execMode
+	cfg.ApplicationName = "playground-pgbouncer-test"
+	cfg.MinConns = 0
+	cfg.MaxConns = 8
+	return cfg
+}
+
+func TestPgBouncerExecModes(t *testing.T) {
+	for _, mode := range []string{"simple_protocol", "describe_exec"} {
+		t.Run(mode, func(t *testing.T) {
+			ctx := context.Background()
+			pool, err := database.NewPool(ctx, pgbouncerConfig(t, mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pool.Close()
+
+			// Many short transactions from several clients shuffle server
+			// connections between them; cached prepared statements would
+			// collide or go missing.
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						var got int
+						if err := pool.QueryRow(ctx, "SELECT $1::int + $2::int", w, i).Scan(&got); err != nil {
+							errs <- err
+							return
+						}
+						if got != w+i {
+							errs <- fmt.Errorf("got %d, want %d", got, w+i)
+							return
+						}
+						err := database.WithTx(ctx, pool, func(tx pgx.Tx) error {
+							_, err := tx.Exec(ctx, "SELECT $1::text", "in a transaction")
+							return err
+						})
+						if err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			var appName string
+			if err := pool.QueryRow(ctx, "SELECT current_setting('application_name')").Scan(&appName); err != nil {
+				t.Fatal(err)
+			}
+			if appName != "playground-pgbouncer-test" {
+				t.Errorf("application_name = %q, want it passed as a startup parameter", appName)
+			}
+		})
+	}
+}
+
+func TestPgBouncerRefusesSessionFeatures(t *testing.T) {
+	ctx := context.Background()
+	cfg := pgbouncerConfig(t, "")
+	pool, err := database.NewPool(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if err := pool.RequireSession("test"); !errors.Is(err, database.ErrSessionUnavailable) {
+		t.Errorf("RequireSession: got %v", err)
+	}
+	if conn, err := pool.AcquireSession(ctx, "test"); !errors.Is(err, database.ErrSessionUnavailable) {
+		if conn != nil {
+			conn.Release()
+		}
+		t.Errorf("AcquireSession: got %v", err)
+	}
+
+	locker := lock.New(pool, "pgbouncer-test")
+	if _, err := locker.TryLock(ctx, "x"); !errors.Is(err, database.ErrSessionUnavailable) {
+		t.Errorf("TryLock: got %v", err)
+	}
+	if _, err := locker.Lock(ctx, "x"); !errors.Is(err, database.ErrSessionUnavailable) {
+		t.Errorf("Lock: got %v", err)
+	}
+	if _, err := notify.NewListener(cfg, notify.DefaultOptions()); !errors.Is(err, database.ErrSessionUnavailable) {
+		t.Errorf("NewListener: got %v", err)
+	}
+
+	// Transaction-level locks need no session and keep working
+	err = database.WithTx(ctx, pool, func(tx pgx.Tx) error {
+		ok, err := locker.TryLockTx(ctx, tx, "x")
+		if err == nil && !ok {
+			err = errors.New("transaction lock not acquired")
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("TryLockTx: %v", err)
+	}
+}
+
+func TestPgBouncerRejectsCachingExecModes(t *testing.T) {
+	for _, mode := range []string{"cache_statement", "cache_describe"} {
+		cfg := database.DefaultConfig()
+		cfg.PgBouncer = true
+		cfg.ExecMode = mode
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("exec_mode %s should be rejected behind PgBouncer", mode)
+		}
+	}
+}
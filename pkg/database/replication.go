@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotStreaming is returned for a standby whose WAL receiver is stopped or
+// not streaming. Its replay position stands still, so "received == replayed"
+// no longer means caught up and its lag is unknown.
+var ErrNotStreaming = errors.New("wal receiver not streaming")
+
+// ReplicationState is one server's view of streaming replication
+type ReplicationState struct {
+	InRecovery  bool
+	WALReceiver string         // standby only: pg_stat_wal_receiver.status, "" when no receiver runs, "unknown" when hidden from this role
+	Lag         *time.Duration // nil when unknown
+}
+
+// replicationStateQuery reports recovery, the WAL receiver status and lag in
+// seconds. On a streaming standby an idle primary sends no new transactions,
+// so received == replayed counts as zero lag rather than "time since last
+// commit". On a primary the lag is the worst replay_lag of its standbys.
+//
+// The receiver status is only visible to superusers and pg_read_all_stats
+// (pg_monitor) members; others see "unknown". Without it a stopped receiver
+// can't be told apart, so the lag is always the time since the last
+// replayed commit, which also grows while the primary is idle.
+const replicationStateQuery = `
+	WITH receiver AS (
+		SELECT COALESCE((SELECT COALESCE(status, 'unknown') FROM pg_stat_wal_receiver), '') AS status
+	)
+	SELECT
+		pg_is_in_recovery(),
+		receiver.status,
+		CASE
+			WHEN NOT pg_is_in_recovery() THEN
+				(SELECT EXTRACT(EPOCH FROM max(replay_lag)) FROM pg_stat_replication)
+			WHEN receiver.status = 'streaming'
+				AND pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+		END
+	FROM receiver
+`
+
+// QueryReplicationState reads the replication state of the server behind
+// pool. For a standby that isn't streaming it returns the state with a nil
+// Lag and an error wrapping ErrNotStreaming. When the role can't see the
+// receiver status, the standby is assumed to stream and only its lag is
+// reported.
+func QueryReplicationState(ctx context.Context, pool *Pool) (ReplicationState, error) {
+	var state ReplicationState
+	var lagSeconds *float64
+	if err := pool.QueryRow(ctx, replicationStateQuery).Scan(&state.InRecovery, &state.WALReceiver, &lagSeconds); err != nil {
+		return state, fmt.Errorf("query replication state: %w", err)
+	}
+
+	if state.InRecovery && state.WALReceiver != "streaming" && state.WALReceiver != "unknown" {
+		receiver := state.WALReceiver
+		if receiver == "" {
+			receiver = "not running"
+		}
+		return state, fmt.Errorf("%w (receiver %s)", ErrNotStreaming, receiver)
+	}
+	if lagSeconds != nil {
+		lag := time.Duration(*lagSeconds * float64(time.Second))
+		state.Lag = &lag
+	}
+	return state, nil
+}
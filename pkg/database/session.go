@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// sessionSettings lists the GUCs to set on every new connection. Named
+// fields win over the same key in Settings.
+func (c *Config) sessionSettings() [][2]string {
+	merged := make(map[string]string, len(c.Settings)+5)
+	for k, v := range c.Settings {
+		merged[k] = v
+	}
+	if c.ApplicationName != "" {
+		merged["application_name"] = c.ApplicationName
+	}
+	if c.SearchPath != "" {
+		merged["search_path"] = c.SearchPath
+	}
+	if c.StatementTimeout > 0 {
+		merged["statement_timeout"] = pgDuration(c.StatementTimeout)
+	}
+	if c.LockTimeout > 0 {
+		merged["lock_timeout"] = pgDuration(c.LockTimeout)
+	}
+	if c.IdleInTransactionSessionTimeout > 0 {
+		merged["idle_in_transaction_session_timeout"] = pgDuration(c.IdleInTransactionSessionTimeout)
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([][2]string, len(keys))
+	for i, k := range keys {
+		out[i] = [2]string{k, merged[k]}
+	}
+	return out
+}
+
+// DisableTimeouts turns statement_timeout and lock_timeout off for tools
+// like migrate and seed whose statements run long. They are set to 0 on
+// connect rather than left unset, so values from Settings, the DSN's
+// options or the role's defaults don't apply either. Behind PgBouncer
+// nothing is set on connect, so server-side defaults still do.
+func (c *Config) DisableTimeouts() {
+	c.StatementTimeout, c.LockTimeout = 0, 0
+	if c.Settings == nil {
+		c.Settings = make(map[string]string, 2)
+	}
+	c.Settings["statement_timeout"] = "0"
+	c.Settings["lock_timeout"] = "0"
+}
+
+// ApplySession sets the configured session parameters on conn in one round
+// trip. NewPool runs it from AfterConnect; pass it to stdlib.OptionAfterConnect
+// for database/sql.
+func (c *Config) ApplySession(ctx context.Context, conn *pgx.Conn) error {
+	settings := c.sessionSettings()
+	if len(settings) == 0 {
+		return nil
+	}
+
+	// set_config takes the name as a parameter, so no identifier quoting needed
+	calls := make([]string, len(settings))
+	args := make([]any, 0, len(settings)*2)
+	for i, kv := range settings {
+		calls[i] = fmt.Sprintf("set_config($%d, $%d, false)", i*2+1, i*2+2)
+		args = append(args, kv[0], kv[1])
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT "+strings.Join(calls, ", "), args...); err != nil {
+		return fmt.Errorf("apply session settings: %w", err)
+	}
+	return nil
+}
+
+// pgDuration renders d in milliseconds, the unit Postgres timeouts default to
+func pgDuration(d time.Duration) string {
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
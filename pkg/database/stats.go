@@ -0,0 +1,174 @@
+package database
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PoolStats is a point-in-time snapshot of pool activity.
+// Counters and durations are cumulative since the pool was created.
+type PoolStats struct {
+	AcquireCount         int64         `json:"acquire_count"`
+	AcquireDuration      time.Duration `json:"acquire_duration_ns"`
+	CanceledAcquireCount int64         `json:"canceled_acquire_count"`
+	EmptyAcquireCount    int64         `json:"empty_acquire_count"` // acquires that had to wait for a conn
+	EmptyAcquireWaitTime time.Duration `json:"empty_acquire_wait_ns"`
+
+	AcquiredConns     int32 `json:"acquired_conns"`
+	ConstructingConns int32 `json:"constructing_conns"`
+	IdleConns         int32 `json:"idle_conns"`
+	TotalConns        int32 `json:"total_conns"`
+	MaxConns          int32 `json:"max_conns"`
+
+	NewConnsCount           int64 `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64 `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64 `json:"max_idle_destroy_count"`
+
+	// From our BeforeAcquire/AfterRelease hooks
+	ReleaseCount int64         `json:"release_count"`
+	HeldDuration time.Duration `json:"held_duration_ns"` // total time conns spent checked out
+}
+
+// Stats returns pool statistics
+func (p *Pool) Stats() PoolStats {
+	stat := p.Pool.Stat()
+	stats := PoolStats{
+		AcquireCount:            stat.AcquireCount(),
+		AcquireDuration:         stat.AcquireDuration(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		EmptyAcquireWaitTime:    stat.EmptyAcquireWaitTime(),
+		AcquiredConns:           stat.AcquiredConns(),
+		ConstructingConns:       stat.ConstructingConns(),
+		IdleConns:               stat.IdleConns(),
+		TotalConns:              stat.TotalConns(),
+		MaxConns:                stat.MaxConns(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+	}
+	if p.counters != nil {
+		stats.ReleaseCount = p.counters.releases.Load()
+		stats.HeldDuration = time.Duration(p.counters.heldNanos.Load())
+	}
+	return stats
+}
+
+// String keeps the old one-line summary for logs
+func (s PoolStats) String() string {
+	return fmt.Sprintf(
+		"Pool Stats - Total: %d, Idle: %d, InUse: %d, MaxConns: %d",
+		s.TotalConns, s.IdleConns, s.AcquiredConns, s.MaxConns,
+	)
+}
+
+// poolCounters tracks what pgxpool doesn't: how long conns are held
+type poolCounters struct {
+	releases   atomic.Int64
+	heldNanos  atomic.Int64
+	acquiredAt sync.Map // *pgx.Conn -> time.Time
+}
+
+func (c *poolCounters) acquired(conn *pgx.Conn) {
+	c.acquiredAt.Store(conn, time.Now())
+}
+
+func (c *poolCounters) released(conn *pgx.Conn) {
+	c.releases.Add(1)
+	if at, ok := c.acquiredAt.LoadAndDelete(conn); ok {
+		c.heldNanos.Add(int64(time.Since(at.(time.Time))))
+	}
+}
+
+// closed forgets conn. A conn destroyed instead of released (broken, or
+// closed mid-query) never reaches AfterRelease.
+func (c *poolCounters) closed(conn *pgx.Conn) {
+	c.acquiredAt.Delete(conn)
+}
+
+type poolMetric struct {
+	name  string
+	help  string
+	kind  string // counter or gauge
+	value func(PoolStats) float64
+}
+
+var poolMetrics = []poolMetric{
+	{"pgxpool_acquire_total", "Connections acquired from the pool.", "counter",
+		func(s PoolStats) float64 { return float64(s.AcquireCount) }},
+	{"pgxpool_acquire_duration_seconds_total", "Time spent acquiring connections.", "counter",
+		func(s PoolStats) float64 { return s.AcquireDuration.Seconds() }},
+	{"pgxpool_canceled_acquire_total", "Acquires canceled by their context.", "counter",
+		func(s PoolStats) float64 { return float64(s.CanceledAcquireCount) }},
+	{"pgxpool_empty_acquire_total", "Acquires that waited because no idle connection was available.", "counter",
+		func(s PoolStats) float64 { return float64(s.EmptyAcquireCount) }},
+	{"pgxpool_empty_acquire_wait_seconds_total", "Time spent waiting in empty acquires.", "counter",
+		func(s PoolStats) float64 { return s.EmptyAcquireWaitTime.Seconds() }},
+	{"pgxpool_acquired_conns", "Connections currently checked out.", "gauge",
+		func(s PoolStats) float64 { return float64(s.AcquiredConns) }},
+	{"pgxpool_constructing_conns", "Connections currently being established.", "gauge",
+		func(s PoolStats) float64 { return float64(s.ConstructingConns) }},
+	{"pgxpool_idle_conns", "Idle connections.", "gauge",
+		func(s PoolStats) float64 { return float64(s.IdleConns) }},
+	{"pgxpool_total_conns", "Total connections (idle, acquired and constructing).", "gauge",
+		func(s PoolStats) float64 { return float64(s.TotalConns) }},
+	{"pgxpool_max_conns", "Configured maximum pool size.", "gauge",
+		func(s PoolStats) float64 { return float64(s.MaxConns) }},
+	{"pgxpool_new_conns_total", "Connections opened.", "counter",
+		func(s PoolStats) float64 { return float64(s.NewConnsCount) }},
+	{"pgxpool_max_lifetime_destroy_total", "Connections closed for exceeding MaxConnLifetime.", "counter",
+		func(s PoolStats) float64 { return float64(s.MaxLifetimeDestroyCount) }},
+	{"pgxpool_max_idle_destroy_total", "Connections closed for exceeding MaxConnIdleTime.", "counter",
+		func(s PoolStats) float64 { return float64(s.MaxIdleDestroyCount) }},
+	{"pgxpool_release_total", "Connections returned to the pool.", "counter",
+		func(s PoolStats) float64 { return float64(s.ReleaseCount) }},
+	{"pgxpool_held_seconds_total", "Time connections spent checked out.", "counter",
+		func(s PoolStats) float64 { return s.HeldDuration.Seconds() }},
+}
+
+// WritePrometheus writes snapshots in the Prometheus text exposition format,
+// labelling each with pool="<name>".
+func WritePrometheus(w io.Writer, stats map[string]PoolStats) error {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, m := range poolMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.kind)
+		for _, name := range names {
+			fmt.Fprintf(bw, "%s{pool=%q} %g\n", m.name, name, m.value(stats[name]))
+		}
+	}
+	return bw.Flush()
+}
+
+// MetricsHandler serves the pool's statistics for Prometheus to scrape
+func (p *Pool) MetricsHandler() http.Handler {
+	return metricsHandler(func() map[string]PoolStats {
+		return map[string]PoolStats{"default": p.Stats()}
+	})
+}
+
+// MetricsHandler serves statistics for every node in the cluster
+func (c *Cluster) MetricsHandler() http.Handler {
+	return metricsHandler(c.Stats)
+}
+
+func metricsHandler(snapshot func() map[string]PoolStats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WritePrometheus(w, snapshot())
+	})
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func acquiredConns(c *poolCounters) int {
+	n := 0
+	c.acquiredAt.Range(func(any, any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestPoolCountersForgetConns(t *testing.T) {
+	var c poolCounters
+	released, destroyed := &pgx.Conn{}, &pgx.Conn{}
+
+	c.acquired(released)
+	c.acquired(destroyed)
+	c.released(released)
+	c.closed(destroyed)
+
+	if n := acquiredConns(&c); n != 0 {
+		t.Errorf("%d conns still tracked after release and close", n)
+	}
+	if c.releases.Load() != 1 {
+		t.Errorf("releases = %d, want 1", c.releases.Load())
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	var b strings.Builder
+	err := WritePrometheus(&b, map[string]PoolStats{
+		"replica-0": {AcquireCount: 7},
+		"primary":   {AcquireCount: 3, MaxConns: 25},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE pgxpool_acquire_total counter\n",
+		"pgxpool_acquire_total{pool=\"primary\"} 3\npgxpool_acquire_total{pool=\"replica-0\"} 7\n",
+		"pgxpool_max_conns{pool=\"primary\"} 25\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// configureTLS swaps pgx's static TLS settings for ones that re-read
+// certificates from disk whenever they change, so rotated certs are picked
+// up by new connections without restarting the pool.
+func (c *Config) configureTLS(cc *pgconn.Config) error {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	// sslmode=allow/prefer put a TLS attempt and a plaintext attempt in
+	// Fallbacks; only replace the TLS ones.
+	if cc.TLSConfig != nil {
+		cc.TLSConfig = tlsConfig
+	}
+	for _, fb := range cc.Fallbacks {
+		if fb.TLSConfig != nil {
+			fb.TLSConfig = tlsConfig
+		}
+	}
+	return nil
+}
+
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	if c.SSLMode == "disable" {
+		return nil, nil
+	}
+
+	certs := &certReloader{
+		rootCertFile: c.SSLRootCert,
+		certFile:     c.SSLCert,
+		keyFile:      c.SSLKey,
+	}
+	// Load once up front so bad paths fail in NewPool, not on first connect
+	if _, err := certs.rootCAs(); err != nil {
+		return nil, err
+	}
+	if _, err := certs.clientCertificate(nil); err != nil {
+		return nil, err
+	}
+
+	serverName := c.SSLServerName
+	if serverName == "" {
+		serverName = c.Host
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:           serverName,
+		GetClientCertificate: certs.clientCertificate,
+		// We verify in VerifyConnection so the CA pool can be reloaded
+		InsecureSkipVerify: true,
+	}
+
+	mode := c.SSLMode
+	if mode == "require" && c.SSLRootCert != "" {
+		// libpq treats require + root cert as verify-ca
+		mode = "verify-ca"
+	}
+
+	switch mode {
+	case "allow", "prefer", "require":
+		// Encrypt only, no verification
+	case "verify-ca", "verify-full":
+		verifyHost := mode == "verify-full"
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			roots, err := certs.rootCAs()
+			if err != nil {
+				return err
+			}
+			opts := x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: x509.NewCertPool(),
+			}
+			if verifyHost {
+				opts.DNSName = serverName
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err = cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	default:
+		return nil, fmt.Errorf("unknown sslmode %q", c.SSLMode)
+	}
+
+	return tlsConfig, nil
+}
+
+// certReloader caches the CA bundle and client key pair, reloading them when
+// the files' modification times change.
+type certReloader struct {
+	rootCertFile string
+	certFile     string
+	keyFile      string
+
+	mu       sync.Mutex
+	rootsMod time.Time
+	roots    *x509.CertPool
+	certMod  time.Time
+	cert     *tls.Certificate
+}
+
+// rootCAs returns the CA pool, or nil to use the system roots
+func (r *certReloader) rootCAs() (*x509.CertPool, error) {
+	if r.rootCertFile == "" {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.rootCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("stat sslrootcert: %w", err)
+	}
+	if r.roots != nil && info.ModTime().Equal(r.rootsMod) {
+		return r.roots, nil
+	}
+
+	pem, err := os.ReadFile(r.rootCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("read sslrootcert: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", r.rootCertFile)
+	}
+
+	r.roots = pool
+	r.rootsMod = info.ModTime()
+	return r.roots, nil
+}
+
+func (r *certReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if r.certFile == "" {
+		// An empty certificate tells the server we have none to offer
+		return &tls.Certificate{}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("stat sslcert: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("stat sslkey: %w", err)
+	}
+	mod := certInfo.ModTime()
+	if keyInfo.ModTime().After(mod) {
+		mod = keyInfo.ModTime()
+	}
+	if r.cert != nil && mod.Equal(r.certMod) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load client certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.certMod = mod
+	return r.cert, nil
+}
@@ -0,0 +1,377 @@
+package database
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a throwaway certificate authority, like scripts/gen-certs.sh makes
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue signs a server certificate for hosts, or a client certificate for
+// the user cn when hosts is empty
+func (ca *testCA) issue(t *testing.T, cn string, hosts ...string) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := ca.issuePEM(t, cn, hosts...)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func (ca *testCA) issuePEM(t *testing.T, cn string, hosts ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if len(hosts) > 0 {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeAt writes data to path with the given modification time, so tests
+// control what certReloader sees
+func writeAt(t *testing.T, path string, data []byte, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake runs a TLS handshake between client and a loopback server
+// presenting serverCert. If clientCAs is set, the server requires a client
+// certificate signed by it and the client's CN is returned.
+func handshake(t *testing.T, client *tls.Config, serverCert tls.Certificate, clientCAs *x509.CertPool) (string, error) {
+	t.Helper()
+	server := &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	if clientCAs != nil {
+		server.ClientAuth = tls.RequireAndVerifyClientCert
+		server.ClientCAs = clientCAs
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	deadline := time.Now().Add(5 * time.Second)
+
+	peer := make(chan string, 1)
+	go func() {
+		cn := ""
+		defer func() { peer <- cn }()
+		raw, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn := raw.(*tls.Conn)
+		defer conn.Close()
+		conn.SetDeadline(deadline)
+		if err := conn.Handshake(); err != nil {
+			return
+		}
+		if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			cn = certs[0].Subject.CommonName
+		}
+	}()
+
+	raw, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := tls.Client(raw, client)
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	err = conn.Handshake()
+	if err == nil {
+		// TLS 1.3 clients finish before the server has checked their
+		// certificate; a rejection shows up on the first read
+		if _, err = conn.Read(make([]byte, 1)); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	return <-peer, err
+}
+
+func TestTLSModes(t *testing.T) {
+	ca := newTestCA(t, "playground-ca")
+	other := newTestCA(t, "someone-else")
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	otherFile := filepath.Join(dir, "other.crt")
+	writeAt(t, caFile, ca.pem, time.Now())
+	writeAt(t, otherFile, other.pem, time.Now())
+
+	serverCert := ca.issue(t, "localhost", "localhost", "127.0.0.1")
+
+	tests := []struct {
+		name       string
+		mode       string
+		host       string
+		serverName string
+		rootCert   string
+		ok         bool
+	}{
+		{"verify-full", "verify-full", "localhost", "", caFile, true},
+		{"verify-full by IP", "verify-full", "127.0.0.1", "", caFile, true},
+		{"verify-full hostname mismatch", "verify-full", "db.example.com", "", caFile, false},
+		{"verify-full sslservername", "verify-full", "10.0.0.9", "localhost", caFile, true},
+		{"verify-full wrong CA", "verify-full", "localhost", "", otherFile, false},
+		{"verify-ca ignores hostname", "verify-ca", "db.example.com", "", caFile, true},
+		{"verify-ca wrong CA", "verify-ca", "localhost", "", otherFile, false},
+		{"require skips verification", "require", "db.example.com", "", "", true},
+		{"require with root cert acts as verify-ca", "require", "db.example.com", "", otherFile, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Host: tt.host, SSLMode: tt.mode, SSLRootCert: tt.rootCert, SSLServerName: tt.serverName}
+			tlsConfig, err := cfg.tlsConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = handshake(t, tlsConfig, serverCert, nil)
+			if tt.ok && err != nil {
+				t.Errorf("handshake failed: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("handshake succeeded, want a verification error")
+			}
+		})
+	}
+}
+
+func TestTLSDisable(t *testing.T) {
+	tlsConfig, err := (&Config{SSLMode: "disable"}).tlsConfig()
+	if err != nil || tlsConfig != nil {
+		t.Fatalf("disable should give no TLS config, got %v, %v", tlsConfig, err)
+	}
+}
+
+func TestTLSBadPathsFailUpFront(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.crt")
+	for _, cfg := range []*Config{
+		{Host: "localhost", SSLMode: "verify-full", SSLRootCert: missing},
+		{Host: "localhost", SSLMode: "require", SSLCert: missing, SSLKey: missing},
+	} {
+		if _, err := cfg.tlsConfig(); err == nil {
+			t.Errorf("%+v: want an error for a missing file", cfg)
+		}
+	}
+}
+
+func TestTLSRootCertRotation(t *testing.T) {
+	oldCA := newTestCA(t, "old-ca")
+	newCA := newTestCA(t, "new-ca")
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	writeAt(t, caFile, oldCA.pem, start)
+
+	cfg := &Config{Host: "localhost", SSLMode: "verify-full", SSLRootCert: caFile}
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newServer := newCA.issue(t, "localhost", "localhost")
+	if _, err := handshake(t, tlsConfig, newServer, nil); err == nil {
+		t.Fatal("server signed by the new CA accepted before rotation")
+	}
+
+	// Same mod time: the cached pool is still used
+	writeAt(t, caFile, newCA.pem, start)
+	if _, err := handshake(t, tlsConfig, newServer, nil); err == nil {
+		t.Fatal("bundle reloaded although its mod time didn't change")
+	}
+
+	// New mod time: the same tls.Config now trusts the new CA
+	writeAt(t, caFile, newCA.pem, start.Add(time.Second))
+	if _, err := handshake(t, tlsConfig, newServer, nil); err != nil {
+		t.Fatalf("rotated CA not picked up: %v", err)
+	}
+	if _, err := handshake(t, tlsConfig, oldCA.issue(t, "localhost", "localhost"), nil); err == nil {
+		t.Fatal("old CA still trusted after rotation")
+	}
+}
+
+func TestTLSClientCertRotation(t *testing.T) {
+	ca := newTestCA(t, "playground-ca")
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	writeAt(t, caFile, ca.pem, start)
+	certPEM, keyPEM := ca.issuePEM(t, "grug")
+	writeAt(t, certFile, certPEM, start)
+	writeAt(t, keyFile, keyPEM, start)
+
+	cfg := &Config{Host: "localhost", SSLMode: "verify-full", SSLRootCert: caFile, SSLCert: certFile, SSLKey: keyFile}
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	serverCert := ca.issue(t, "localhost", "localhost")
+
+	cn, err := handshake(t, tlsConfig, serverCert, clientCAs)
+	if err != nil || cn != "grug" {
+		t.Fatalf("client cert: cn=%q err=%v, want grug", cn, err)
+	}
+
+	certPEM, keyPEM = ca.issuePEM(t, "grug2")
+	writeAt(t, certFile, certPEM, start.Add(time.Second))
+	writeAt(t, keyFile, keyPEM, start.Add(time.Second))
+	cn, err = handshake(t, tlsConfig, serverCert, clientCAs)
+	if err != nil || cn != "grug2" {
+		t.Fatalf("rotated client cert: cn=%q err=%v, want grug2", cn, err)
+	}
+}
+
+// TestTLSLive connects to the TLS-only instance (make up-tls) with the
+// certificates scripts/gen-certs.sh wrote to ./certs
+func TestTLSLive(t *testing.T) {
+	base := liveConfig(t, "PLAYGROUND_TEST_TLS_URL")
+	certs := filepath.Join("..", "..", "certs")
+	if dir := os.Getenv("PLAYGROUND_TEST_TLS_CERTS"); dir != "" {
+		certs = dir
+	}
+	caFile := filepath.Join(certs, "ca.crt")
+	if _, err := os.Stat(caFile); err != nil {
+		t.Skipf("no CA at %s: run make certs", caFile)
+	}
+	otherFile := filepath.Join(t.TempDir(), "other.crt")
+	writeAt(t, otherFile, newTestCA(t, "someone-else").pem, time.Now())
+
+	tests := []struct {
+		name       string
+		mode       string
+		host       string
+		serverName string
+		rootCert   string
+		clientCert bool
+		ok         bool
+	}{
+		{"verify-full", "verify-full", "localhost", "", caFile, false, true},
+		{"verify-full by IP", "verify-full", "127.0.0.1", "", caFile, false, true},
+		{"verify-full hostname mismatch", "verify-full", "localhost", "db.example.com", caFile, false, false},
+		{"verify-full wrong CA", "verify-full", "localhost", "", otherFile, false, false},
+		{"verify-ca ignores hostname", "verify-ca", "localhost", "db.example.com", caFile, false, true},
+		{"verify-ca wrong CA", "verify-ca", "localhost", "", otherFile, false, false},
+		{"verify-full with client cert", "verify-full", "localhost", "", caFile, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := *base
+			cfg.Host = tt.host
+			cfg.SSLMode = tt.mode
+			cfg.SSLRootCert = tt.rootCert
+			cfg.SSLServerName = tt.serverName
+			if tt.clientCert {
+				cfg.SSLCert = filepath.Join(certs, "client.crt")
+				cfg.SSLKey = filepath.Join(certs, "client.key")
+			}
+			cfg.MinConns = 0
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			pool, err := NewPool(ctx, &cfg)
+			if !tt.ok {
+				if err == nil {
+					pool.Close()
+					t.Fatal("connected, want a verification error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pool.Close()
+
+			var ssl bool
+			var clientDN *string
+			err = pool.QueryRow(ctx, "SELECT ssl, client_dn FROM pg_stat_ssl WHERE pid = pg_backend_pid()").Scan(&ssl, &clientDN)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ssl {
+				t.Error("connection isn't using TLS")
+			}
+			if tt.clientCert && (clientDN == nil || *clientDN != "/CN=grug") {
+				t.Errorf("client_dn = %v, want /CN=grug", clientDN)
+			}
+		})
+	}
+}
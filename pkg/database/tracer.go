@@ -0,0 +1,280 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// LatencyBuckets are the upper bounds of the per-statement histogram buckets.
+// StatementStats.Buckets has one extra slot for everything slower.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// otherStatements collects timings once MaxStatements distinct SQL texts are tracked
+const otherStatements = "<other>"
+
+// StatementStats aggregates timings for one normalized SQL text
+type StatementStats struct {
+	SQL     string
+	Calls   int64
+	Errors  int64
+	Rows    int64
+	Total   time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	Buckets []int64 // counts per LatencyBuckets bound, plus one overflow bucket
+}
+
+// Mean returns the average latency
+func (s StatementStats) Mean() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+// Quantile estimates the q-th latency quantile (0..1) from the histogram,
+// returning the upper bound of the bucket it falls in.
+func (s StatementStats) Quantile(q float64) time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.Calls))
+	var seen int64
+	for i, n := range s.Buckets {
+		seen += n
+		if seen > rank || seen == s.Calls {
+			if i < len(LatencyBuckets) {
+				return LatencyBuckets[i]
+			}
+			break
+		}
+	}
+	return s.Max
+}
+
+// QueryTracer implements pgx's QueryTracer, BatchTracer and CopyFromTracer.
+// It logs statements slower than SlowThreshold and keeps a latency histogram
+// per statement.
+type QueryTracer struct {
+	Logger        *slog.Logger
+	SlowThreshold time.Duration // 0 disables slow query logging
+	MaxStatements int           // distinct statements tracked before lumping into "<other>"
+
+	mu    sync.Mutex
+	stats map[string]*StatementStats
+}
+
+// NewQueryTracer creates a tracer logging to logger (slog.Default() if nil)
+func NewQueryTracer(logger *slog.Logger, slowThreshold time.Duration) *QueryTracer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &QueryTracer{
+		Logger:        logger,
+		SlowThreshold: slowThreshold,
+		MaxStatements: 1000,
+		stats:         make(map[string]*StatementStats),
+	}
+}
+
+type traceKey struct{}
+
+type queryTrace struct {
+	start time.Time
+	sql   string
+	args  []any
+}
+
+// batchTrace times each queued query from the previous result, since pgx
+// only reports when each result arrives.
+type batchTrace struct {
+	last time.Time
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, traceKey{}, &queryTrace{start: time.Now(), sql: data.SQL, args: data.Args})
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	qt, ok := ctx.Value(traceKey{}).(*queryTrace)
+	if !ok {
+		return
+	}
+	t.observe(ctx, qt.sql, qt.args, time.Since(qt.start), data.CommandTag, data.Err)
+}
+
+func (t *QueryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+	return context.WithValue(ctx, traceKey{}, &batchTrace{last: time.Now()})
+}
+
+func (t *QueryTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	bt, ok := ctx.Value(traceKey{}).(*batchTrace)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	t.observe(ctx, data.SQL, data.Args, now.Sub(bt.last), data.CommandTag, data.Err)
+	bt.last = now
+}
+
+func (t *QueryTracer) TraceBatchEnd(context.Context, *pgx.Conn, pgx.TraceBatchEndData) {}
+
+func (t *QueryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	sql := fmt.Sprintf("COPY %s (%s) FROM STDIN", data.TableName.Sanitize(), strings.Join(data.ColumnNames, ", "))
+	return context.WithValue(ctx, traceKey{}, &queryTrace{start: time.Now(), sql: sql})
+}
+
+func (t *QueryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	qt, ok := ctx.Value(traceKey{}).(*queryTrace)
+	if !ok {
+		return
+	}
+	t.observe(ctx, qt.sql, nil, time.Since(qt.start), data.CommandTag, data.Err)
+}
+
+func (t *QueryTracer) observe(ctx context.Context, sql string, args []any, elapsed time.Duration, tag pgconn.CommandTag, err error) {
+	key := normalizeSQL(sql)
+	rows := tag.RowsAffected()
+
+	t.mu.Lock()
+	s, ok := t.stats[key]
+	if !ok {
+		if t.MaxStatements > 0 && len(t.stats) >= t.MaxStatements {
+			key = otherStatements
+			s = t.stats[key]
+		}
+		if s == nil {
+			s = &StatementStats{SQL: key, Min: elapsed, Buckets: make([]int64, len(LatencyBuckets)+1)}
+			t.stats[key] = s
+		}
+	}
+	s.Calls++
+	s.Rows += rows
+	s.Total += elapsed
+	if err != nil {
+		s.Errors++
+	}
+	if elapsed < s.Min {
+		s.Min = elapsed
+	}
+	if elapsed > s.Max {
+		s.Max = elapsed
+	}
+	s.Buckets[sort.Search(len(LatencyBuckets), func(i int) bool { return elapsed <= LatencyBuckets[i] })]++
+	t.mu.Unlock()
+
+	if t.SlowThreshold <= 0 || elapsed < t.SlowThreshold {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.Duration("duration", elapsed),
+		slog.String("sql", key),
+		slog.Any("args", sanitizeArgs(args)),
+		slog.Int64("rows", rows),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			attrs = append(attrs, slog.String("sqlstate", pgErr.Code))
+		}
+	}
+	t.Logger.LogAttrs(ctx, slog.LevelWarn, "slow query", attrs...)
+}
+
+// Statements returns a snapshot of per-statement stats, slowest total first
+func (t *QueryTracer) Statements() []StatementStats {
+	t.mu.Lock()
+	out := make([]StatementStats, 0, len(t.stats))
+	for _, s := range t.stats {
+		cp := *s
+		cp.Buckets = append([]int64(nil), s.Buckets...)
+		out = append(out, cp)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Total > out[j].Total })
+	return out
+}
+
+// Reset clears all collected statement stats
+func (t *QueryTracer) Reset() {
+	t.mu.Lock()
+	t.stats = make(map[string]*StatementStats)
+	t.mu.Unlock()
+}
+
+// normalizeSQL collapses whitespace so the same query formatted differently
+// (as our multi-line literals often are) lands in one bucket.
+func normalizeSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
+
+const (
+	maxLoggedArgs   = 20
+	maxLoggedArgLen = 64
+)
+
+// sanitizeArgs renders query args for logs: long values are truncated and
+// raw bytes are summarized so payloads and blobs don't flood the log.
+func sanitizeArgs(args []any) []string {
+	n := len(args)
+	if n > maxLoggedArgs {
+		n = maxLoggedArgs
+	}
+	out := make([]string, 0, n+1)
+	for _, arg := range args[:n] {
+		var s string
+		switch v := arg.(type) {
+		case nil:
+			s = "NULL"
+		case json.RawMessage:
+			s = string(v)
+		case []byte:
+			s = fmt.Sprintf("<%d bytes>", len(v))
+		case string:
+			s = v
+		default:
+			s = fmt.Sprintf("%v", v)
+		}
+		if len(s) > maxLoggedArgLen {
+			// Cut at a character boundary so the log line stays valid UTF-8
+			cut := maxLoggedArgLen
+			for cut > 0 && !utf8.RuneStart(s[cut]) {
+				cut--
+			}
+			s = s[:cut] + "..."
+		}
+		out = append(out, s)
+	}
+	if len(args) > n {
+		out = append(out, fmt.Sprintf("(%d more)", len(args)-n))
+	}
+	return out
+}
@@ -0,0 +1,25 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeArgsTruncatesOnCharacters(t *testing.T) {
+	// 63 ASCII bytes, then a 2-byte character straddling the limit
+	arg := strings.Repeat("a", maxLoggedArgLen-1) + "ö" + strings.Repeat("b", 10)
+	got := sanitizeArgs([]any{arg})[0]
+	if !utf8.ValidString(got) {
+		t.Fatalf("truncated arg %q isn't valid UTF-8", got)
+	}
+	if want := strings.Repeat("a", maxLoggedArgLen-1) + "..."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSlowQueryLogOffByDefault(t *testing.T) {
+	if d := DefaultConfig().SlowQueryThreshold; d != 0 {
+		t.Errorf("SlowQueryThreshold defaults to %v, want 0 (off)", d)
+	}
+}
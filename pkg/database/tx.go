@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RetryPolicy controls how WithTxOptions retries transactions that lose a
+// serialization or deadlock race. The zero value runs the closure once.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts, including the first
+	BaseDelay   time.Duration // backoff before the second attempt
+	MaxDelay    time.Duration // cap on any single backoff
+}
+
+// DefaultRetryPolicy returns sensible defaults for SERIALIZABLE workloads
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    time.Second,
+	}
+}
+
+// backoff returns a full-jitter delay for the given retry (1 = first retry)
+func (r RetryPolicy) backoff(retry int) time.Duration {
+	delay := r.BaseDelay
+	for i := 1; i < retry && delay < r.MaxDelay; i++ {
+		delay *= 2
+	}
+	if r.MaxDelay > 0 && delay > r.MaxDelay {
+		delay = r.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// IsRetryable reports whether err is a serialization failure (40001) or
+// deadlock (40P01), i.e. the whole transaction can safely be run again.
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+type txKey struct{}
+
+// ContextWithTx returns a copy of ctx carrying tx. WithTx, WithTxContext and
+// WithTxOptions called with the result nest inside tx using savepoints instead
+// of starting an unrelated transaction on another pooled connection.
+func ContextWithTx(ctx context.Context, tx pgx.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns the transaction carried by ctx, if any
+func TxFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+// WithTx runs a function within a transaction.
+// If ctx already carries a transaction, fn runs inside a savepoint on it.
+func WithTx(ctx context.Context, pool *Pool, fn func(pgx.Tx) error) error {
+	_, err := WithTxOptions(ctx, pool, pgx.TxOptions{}, RetryPolicy{}, fn)
+	return err
+}
+
+// WithTxContext is WithTx for functions that call other transactional helpers.
+// fn receives a context carrying the transaction; pass it down so nested
+// WithTx calls become SAVEPOINT / RELEASE / ROLLBACK TO on the same connection.
+//
+// pgx.Tx is not safe for concurrent use, so don't share that context across
+// goroutines.
+func WithTxContext(ctx context.Context, pool *Pool, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	_, err := withTx(ctx, pool, pgx.TxOptions{}, RetryPolicy{}, fn)
+	return err
+}
+
+// WithTxOptions runs fn in a transaction started with txOptions (isolation
+// level, access mode, deferrable). If the transaction fails with a
+// serialization failure or deadlock, the whole closure is re-run after a
+// jittered backoff, up to retry.MaxAttempts times. fn must therefore be safe
+// to call more than once.
+//
+// When ctx already carries a transaction, fn runs once inside a savepoint:
+// txOptions can't change an open transaction and retry is left to the
+// outermost call, since a serialization failure dooms the whole transaction.
+//
+// attempts is the number of times fn was started, even when err is non-nil.
+func WithTxOptions(ctx context.Context, pool *Pool, txOptions pgx.TxOptions, retry RetryPolicy, fn func(pgx.Tx) error) (attempts int, err error) {
+	return withTx(ctx, pool, txOptions, retry, func(_ context.Context, tx pgx.Tx) error {
+		return fn(tx)
+	})
+}
+
+func withTx(ctx context.Context, pool *Pool, txOptions pgx.TxOptions, retry RetryPolicy, fn func(context.Context, pgx.Tx) error) (attempts int, err error) {
+	if outer, ok := TxFromContext(ctx); ok {
+		return 1, runTx(ctx, outer.Begin, fn)
+	}
+
+	begin := func(ctx context.Context) (pgx.Tx, error) {
+		return pool.BeginTx(ctx, txOptions)
+	}
+	for {
+		attempts++
+		err = runTx(ctx, begin, fn)
+		if err == nil || !IsRetryable(err) || attempts >= retry.MaxAttempts {
+			if err != nil && attempts > 1 {
+				err = fmt.Errorf("transaction failed after %d attempts: %w", attempts, err)
+			}
+			return attempts, err
+		}
+
+		timer := time.NewTimer(retry.backoff(attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, fmt.Errorf("retry transaction: %w (last error: %v)", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// runTx begins a transaction (or savepoint, when begin is pgx.Tx.Begin),
+// runs fn with a context carrying it, and commits or rolls back.
+func runTx(ctx context.Context, begin func(context.Context) (pgx.Tx, error), fn func(context.Context, pgx.Tx) error) error {
+	tx, err := begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx)
+			panic(p) // re-throw
+		}
+	}()
+
+	if err := fn(ContextWithTx(ctx, tx), tx); err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			return fmt.Errorf("rollback failed: %v, original error: %w", rbErr, err)
+		}
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package explain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind names a problem found in a plan
+type Kind string
+
+const (
+	SeqScanLargeTable Kind = "seq_scan"       // seq scan reading many rows
+	IndexOpportunity  Kind = "index"          // seq scan whose filter throws most rows away
+	EstimateMiss      Kind = "estimate"       // planner's row estimate off by EstimateFactor or more
+	SortSpill         Kind = "sort_spill"     // sort went to disk
+	HashSpill         Kind = "hash_spill"     // hash table split into batches on disk
+	NestedLoopLoops   Kind = "nested_loop"    // nested loop probing its inner side many times
+	FilteredIndexScan Kind = "filtered_index" // index scan whose filter throws most rows away
+)
+
+// Finding is one problem, attached to the node it was found on
+type Finding struct {
+	Node    *Node
+	Kind    Kind
+	Message string
+}
+
+func (f Finding) String() string { return fmt.Sprintf("%s: %s", f.Node.Label(), f.Message) }
+
+// Thresholds tune Check
+type Thresholds struct {
+	LargeTableRows  float64 // seq scans reading at least this many rows are flagged
+	EstimateFactor  float64 // flag estimates off by this factor
+	EstimateMinRows float64 // ignore estimate misses where both sides are below this
+	NestedLoops     float64 // flag nested loops whose inner side runs this many times
+	FilterRatio     float64 // share of scanned rows a filter must discard to suggest an index
+}
+
+// DefaultThresholds returns reasonable defaults
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		LargeTableRows:  10000,
+		EstimateFactor:  10,
+		EstimateMinRows: 100,
+		NestedLoops:     1000,
+		FilterRatio:     0.9,
+	}
+}
+
+// Check walks the plan and returns the problems it finds, in plan order.
+// Without ANALYZE there are no actual rows or loops, so only spills and
+// seq scans judged by estimated rows are reported.
+func (p *Plan) Check(th Thresholds) []Finding {
+	analyzed := p.Analyzed()
+	var findings []Finding
+	add := func(n *Node, kind Kind, format string, args ...any) {
+		findings = append(findings, Finding{Node: n, Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+
+	p.Walk(func(n *Node) {
+		scanned := n.PlanRows
+		if analyzed {
+			scanned = n.RowsScanned()
+		}
+
+		switch n.NodeType {
+		case "Seq Scan", "Parallel Seq Scan":
+			if scanned < th.LargeTableRows {
+				break
+			}
+			if analyzed && n.Filter != "" && discarded(n) >= th.FilterRatio {
+				add(n, IndexOpportunity, "reads %s rows and the filter discards %.0f%%; an index on the columns referenced by filter %s may help",
+					count(scanned), 100*discarded(n), n.Filter)
+			} else {
+				add(n, SeqScanLargeTable, "sequential scan over %s rows", count(scanned))
+			}
+
+		case "Index Scan", "Index Only Scan", "Bitmap Heap Scan":
+			if analyzed && n.Filter != "" && scanned >= th.LargeTableRows && discarded(n) >= th.FilterRatio {
+				add(n, FilteredIndexScan, "filter %s discards %.0f%% of the rows the index returns; the index doesn't cover it",
+					n.Filter, 100*discarded(n))
+			}
+
+		case "Sort", "Incremental Sort":
+			if n.SortSpaceType == "Disk" || strings.HasPrefix(n.SortMethod, "external") {
+				add(n, SortSpill, "sort spilled %d kB to disk (%s); raise work_mem or sort fewer rows", n.SortSpaceUsed, n.SortMethod)
+			}
+
+		case "Hash":
+			if n.HashBatches > 1 {
+				add(n, HashSpill, "hash split into %d batches on disk; raise work_mem", n.HashBatches)
+			}
+
+		case "Nested Loop":
+			if analyzed && len(n.Plans) == 2 && n.Plans[1].ActualLoops >= th.NestedLoops {
+				add(n, NestedLoopLoops, "inner side ran %s times; a hash or merge join may be cheaper", count(n.Plans[1].ActualLoops))
+			}
+		}
+
+		if analyzed && n.ActualLoops > 0 {
+			est, actual := n.PlanRows, n.ActualRows
+			if max(est, actual) >= th.EstimateMinRows && max(est, actual) >= th.EstimateFactor*max(min(est, actual), 1) {
+				dir := "under"
+				if est > actual {
+					dir = "over"
+				}
+				add(n, EstimateMiss, "planner %sestimated rows: expected %s, got %s; ANALYZE the table or add extended statistics",
+					dir, count(est), count(actual))
+			}
+		}
+	})
+	return findings
+}
+
+// discarded is the share of scanned rows the node's filter threw away
+func discarded(n *Node) float64 {
+	if n.ActualRows+n.RowsRemovedFilter == 0 {
+		return 0
+	}
+	return n.RowsRemovedFilter / (n.ActualRows + n.RowsRemovedFilter)
+}
+
+// count formats a row count compactly, e.g. 12.5k
+func count(n float64) string {
+	switch {
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", n/1e6)
+	case n >= 1e4:
+		return fmt.Sprintf("%.1fk", n/1e3)
+	}
+	return fmt.Sprintf("%.0f", n)
+}
@@ -0,0 +1,171 @@
+package explain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// Plan is the output of EXPLAIN (FORMAT JSON). Times are in milliseconds.
+type Plan struct {
+	Root          *Node   `json:"Plan"`
+	PlanningTime  float64 `json:"Planning Time"`
+	ExecutionTime float64 `json:"Execution Time"`
+}
+
+// Node is one plan node. Fields only present with ANALYZE or BUFFERS are
+// zero without them.
+type Node struct {
+	NodeType           string   `json:"Node Type"`
+	ParentRelationship string   `json:"Parent Relationship"`
+	RelationName       string   `json:"Relation Name"`
+	Schema             string   `json:"Schema"`
+	Alias              string   `json:"Alias"`
+	IndexName          string   `json:"Index Name"`
+	JoinType           string   `json:"Join Type"`
+	Strategy           string   `json:"Strategy"`
+	ParallelAware      bool     `json:"Parallel Aware"`
+	StartupCost        float64  `json:"Startup Cost"`
+	TotalCost          float64  `json:"Total Cost"`
+	PlanRows           float64  `json:"Plan Rows"`
+	PlanWidth          int      `json:"Plan Width"`
+	ActualStartupTime  float64  `json:"Actual Startup Time"`
+	ActualTotalTime    float64  `json:"Actual Total Time"` // per loop
+	ActualRows         float64  `json:"Actual Rows"`       // per loop
+	ActualLoops        float64  `json:"Actual Loops"`
+	Filter             string   `json:"Filter"`
+	RowsRemovedFilter  float64  `json:"Rows Removed by Filter"`
+	IndexCond          string   `json:"Index Cond"`
+	RecheckCond        string   `json:"Recheck Cond"`
+	HashCond           string   `json:"Hash Cond"`
+	JoinFilter         string   `json:"Join Filter"`
+	SortKey            []string `json:"Sort Key"`
+	SortMethod         string   `json:"Sort Method"`
+	SortSpaceUsed      int64    `json:"Sort Space Used"` // kB
+	SortSpaceType      string   `json:"Sort Space Type"` // Memory or Disk
+	HashBatches        int      `json:"Hash Batches"`
+	PeakMemoryUsage    int64    `json:"Peak Memory Usage"` // kB
+	WorkersPlanned     int      `json:"Workers Planned"`
+	WorkersLaunched    int      `json:"Workers Launched"`
+	SharedHitBlocks    int64    `json:"Shared Hit Blocks"`
+	SharedReadBlocks   int64    `json:"Shared Read Blocks"`
+	TempReadBlocks     int64    `json:"Temp Read Blocks"`
+	TempWrittenBlocks  int64    `json:"Temp Written Blocks"`
+	Plans              []*Node  `json:"Plans"`
+
+	// Filled in by Parse
+	InclusiveTime float64 `json:"-"` // ms in this node and its children, all loops
+	ExclusiveTime float64 `json:"-"` // ms in this node alone
+	Depth         int     `json:"-"`
+}
+
+// Parse decodes EXPLAIN (FORMAT JSON) output, which is a one-element
+// array, and works out each node's inclusive and exclusive time.
+func Parse(data []byte) (*Plan, error) {
+	data = bytes.TrimSpace(data)
+	var plan Plan
+	if bytes.HasPrefix(data, []byte("[")) {
+		var plans []Plan
+		if err := json.Unmarshal(data, &plans); err != nil {
+			return nil, fmt.Errorf("decode plan: %w", err)
+		}
+		if len(plans) != 1 {
+			return nil, fmt.Errorf("decode plan: expected one plan, got %d", len(plans))
+		}
+		plan = plans[0]
+	} else if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("decode plan: %w", err)
+	}
+	if plan.Root == nil {
+		return nil, errors.New("decode plan: no Plan node")
+	}
+	plan.Root.time(0, 1)
+	return &plan, nil
+}
+
+// Analyze runs EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) on query. The query
+// really runs, so it happens in a transaction that is rolled back; an
+// UPDATE or DELETE leaves no trace. The rollback runs even if ctx is
+// cancelled, and its failure is returned.
+func Analyze(ctx context.Context, pool *database.Pool, query string, args ...any) (plan *Plan, err error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rbErr := tx.Rollback(context.WithoutCancel(ctx)); rbErr != nil {
+			plan, err = nil, errors.Join(err, fmt.Errorf("roll back explain: %w", rbErr))
+		}
+	}()
+
+	var data []byte
+	if err := tx.QueryRow(ctx, "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) "+query, args...).Scan(&data); err != nil {
+		return nil, fmt.Errorf("explain: %w", err)
+	}
+	return Parse(data)
+}
+
+// Analyzed reports whether the plan has actual times and row counts
+func (p *Plan) Analyzed() bool {
+	return p.Root.ActualLoops > 0
+}
+
+// Walk calls fn for every node, parents before children
+func (p *Plan) Walk(fn func(n *Node)) {
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		fn(n)
+		for _, child := range n.Plans {
+			walk(child)
+		}
+	}
+	walk(p.Root)
+}
+
+// Label is the node type plus what it works on, e.g.
+// "Index Scan using idx_assets_site on assets a"
+func (n *Node) Label() string {
+	label := n.NodeType
+	if n.JoinType != "" && n.JoinType != "Inner" {
+		label = n.JoinType + " " + label
+	}
+	if n.IndexName != "" {
+		label += " using " + n.IndexName
+	}
+	if n.RelationName != "" {
+		label += " on " + n.RelationName
+		if n.Alias != "" && n.Alias != n.RelationName {
+			label += " " + n.Alias
+		}
+	}
+	return label
+}
+
+// RowsScanned is how many rows the node read before its filter, all loops
+func (n *Node) RowsScanned() float64 {
+	return (n.ActualRows + n.RowsRemovedFilter) * max(n.ActualLoops, 1)
+}
+
+// time fills in InclusiveTime and ExclusiveTime. Actual times are per
+// loop, so they are multiplied by loops. Under a Gather, each worker's
+// loops are averaged together, so dividing by the participants (workers
+// plus the leader) keeps the numbers close to wall-clock time.
+func (n *Node) time(depth int, participants float64) {
+	n.Depth = depth
+	n.InclusiveTime = n.ActualTotalTime * n.ActualLoops / participants
+
+	childParticipants := participants
+	if n.NodeType == "Gather" || n.NodeType == "Gather Merge" {
+		childParticipants = float64(n.WorkersLaunched + 1)
+	}
+	children := 0.0
+	for _, child := range n.Plans {
+		child.time(depth+1, childParticipants)
+		children += child.InclusiveTime
+	}
+	n.ExclusiveTime = max(n.InclusiveTime-children, 0)
+}
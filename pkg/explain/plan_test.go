@@ -0,0 +1,122 @@
+package explain
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func loadPlan(t *testing.T, name string) *Plan {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestParse(t *testing.T) {
+	plan := loadPlan(t, "hash_join_batches.json")
+	if !plan.Analyzed() {
+		t.Error("plan with actual times should count as analyzed")
+	}
+	if plan.PlanningTime != 0.3 || plan.ExecutionTime != 122 {
+		t.Errorf("times = %v/%v, want 0.3/122", plan.PlanningTime, plan.ExecutionTime)
+	}
+	hash := plan.Root.Plans[1]
+	if hash.NodeType != "Hash" || hash.HashBatches != 4 || hash.Depth != 1 {
+		t.Errorf("inner node = %s with %d batches at depth %d", hash.NodeType, hash.HashBatches, hash.Depth)
+	}
+	if got := hash.Plans[0].Label(); got != "Index Scan using sites_pkey on sites s" {
+		t.Errorf("label = %q", got)
+	}
+
+	// EXPLAIN output is an array, but a bare object is accepted too
+	bare, err := Parse([]byte(`{"Plan": {"Node Type": "Result", "Plan Rows": 1}}`))
+	if err != nil || bare.Root.NodeType != "Result" || bare.Analyzed() {
+		t.Errorf("bare plan: %+v, %v", bare, err)
+	}
+	for _, bad := range []string{`[]`, `[{"Plan": {}}, {"Plan": {}}]`, `{}`, `not json`} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse(%s): want an error", bad)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	tests := []struct {
+		file                 string
+		node                 func(*Plan) *Node
+		inclusive, exclusive float64
+	}{
+		// Inner side: 0.009 ms x 5000 loops
+		{"nested_loop.json", func(p *Plan) *Node { return p.Root.Plans[1] }, 45, 45},
+		{"nested_loop.json", func(p *Plan) *Node { return p.Root }, 50, 3},
+		// Three participants each report ~300 ms per loop; wall clock is ~300 ms
+		{"gather.json", func(p *Plan) *Node { return p.Root.Plans[0] }, 300, 300},
+		{"gather.json", func(p *Plan) *Node { return p.Root }, 320, 20},
+		{"sort_spill.json", func(p *Plan) *Node { return p.Root }, 95, 75},
+	}
+	for _, tt := range tests {
+		n := tt.node(loadPlan(t, tt.file))
+		if !near(n.InclusiveTime, tt.inclusive) || !near(n.ExclusiveTime, tt.exclusive) {
+			t.Errorf("%s %s: inclusive %v exclusive %v, want %v and %v",
+				tt.file, n.Label(), n.InclusiveTime, n.ExclusiveTime, tt.inclusive, tt.exclusive)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		file string
+		want []Kind
+		msg  string // substring of the first finding's message
+	}{
+		{"seq_scan.json", []Kind{SeqScanLargeTable}, "sequential scan over 100.0k rows"},
+		{"seq_scan_filter.json", []Kind{IndexOpportunity}, "columns referenced by filter ((status)::text = 'maintenance'::text)"},
+		{"estimate_miss.json", []Kind{EstimateMiss}, "underestimated rows: expected 40, got 4000"},
+		{"sort_spill.json", []Kind{SortSpill}, "spilled 7040 kB to disk (external merge)"},
+		{"hash_join_batches.json", []Kind{HashSpill}, "4 batches"},
+		{"nested_loop.json", []Kind{NestedLoopLoops}, "inner side ran 5000 times"},
+		{"gather.json", nil, ""},
+	}
+	for _, tt := range tests {
+		findings := loadPlan(t, tt.file).Check(DefaultThresholds())
+		var kinds []Kind
+		for _, f := range findings {
+			kinds = append(kinds, f.Kind)
+		}
+		if !slices.Equal(kinds, tt.want) {
+			t.Errorf("%s: findings %v, want %v", tt.file, findings, tt.want)
+			continue
+		}
+		if tt.msg != "" && !strings.Contains(findings[0].Message, tt.msg) {
+			t.Errorf("%s: message %q doesn't mention %q", tt.file, findings[0].Message, tt.msg)
+		}
+	}
+}
+
+func TestCheckWithoutAnalyze(t *testing.T) {
+	plan, err := Parse([]byte(`[{"Plan": {
+		"Node Type": "Seq Scan", "Relation Name": "assets", "Plan Rows": 100000,
+		"Filter": "(status = 'active')"
+	}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := plan.Check(DefaultThresholds())
+	if len(findings) != 1 || findings[0].Kind != SeqScanLargeTable {
+		t.Errorf("estimated-only plan: got %v, want a single seq scan finding", findings)
+	}
+}
@@ -0,0 +1,92 @@
+package explain
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Render writes the plan as an indented tree, one line per node, with
+// findings under the node they belong to:
+//
+//	Limit  rows 10 (est 10)  total 41.20 ms  self 0.01 ms (0%)
+//	└─ Sort  rows 10 (est 50)  total 41.19 ms  self 0.69 ms (2%)  key: (count(a.id)) DESC
+//	   │  ! sort spilled 4096 kB to disk (external merge); raise work_mem or sort fewer rows
+//	   └─ Seq Scan on assets a  rows 120 (est 100)  total 40.50 ms  self 40.50 ms (98%)  (status = 'active')
+//	         ! reads 50.0k rows and the filter discards 100%; an index on the columns referenced by filter (status = 'active') may help
+func (p *Plan) Render(w io.Writer, findings []Finding) error {
+	byNode := map[*Node][]Finding{}
+	for _, f := range findings {
+		byNode[f.Node] = append(byNode[f.Node], f)
+	}
+
+	var b strings.Builder
+	if p.Analyzed() {
+		fmt.Fprintf(&b, "Planning %.2f ms, execution %.2f ms\n", p.PlanningTime, p.ExecutionTime)
+	}
+	total := p.Root.InclusiveTime
+
+	var render func(n *Node, prefix, branch, indent string)
+	render = func(n *Node, prefix, branch, indent string) {
+		b.WriteString(prefix + branch + n.Label())
+		if n.ParentRelationship == "InitPlan" || n.ParentRelationship == "SubPlan" {
+			b.WriteString(" [" + n.ParentRelationship + "]")
+		}
+		if p.Analyzed() {
+			fmt.Fprintf(&b, "  rows %s (est %s)", count(n.ActualRows), count(n.PlanRows))
+			if n.ActualLoops > 1 {
+				fmt.Fprintf(&b, " x %s loops", count(n.ActualLoops))
+			}
+			fmt.Fprintf(&b, "  total %.2f ms  self %.2f ms (%.0f%%)", n.InclusiveTime, n.ExclusiveTime, percent(n.ExclusiveTime, total))
+		} else {
+			fmt.Fprintf(&b, "  est rows %s  cost %.2f", count(n.PlanRows), n.TotalCost)
+		}
+		if len(n.SortKey) > 0 {
+			b.WriteString("  key: " + strings.Join(n.SortKey, ", "))
+		}
+		for _, cond := range []string{n.IndexCond, n.HashCond, n.Filter} {
+			if cond != "" {
+				b.WriteString("  " + cond)
+				break
+			}
+		}
+		b.WriteString("\n")
+
+		child := prefix + indent
+		gap := "   "
+		if len(n.Plans) > 0 {
+			gap = "│  "
+		}
+		for _, f := range byNode[n] {
+			fmt.Fprintf(&b, "%s%s! %s\n", child, gap, f.Message)
+		}
+		for i, c := range n.Plans {
+			if i == len(n.Plans)-1 {
+				render(c, child, "└─ ", "   ")
+			} else {
+				render(c, child, "├─ ", "│  ")
+			}
+		}
+	}
+	render(p.Root, "", "", "")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// String renders the plan with findings from DefaultThresholds, or the
+// rendering error
+func (p *Plan) String() string {
+	var b strings.Builder
+	if err := p.Render(&b, p.Check(DefaultThresholds())); err != nil {
+		return "render plan: " + err.Error()
+	}
+	return b.String()
+}
+
+func percent(part, whole float64) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return 100 * part / whole
+}
@@ -0,0 +1,168 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of a check or of the whole report
+type Status string
+
+const (
+	Up       Status = "up"
+	Degraded Status = "degraded"
+	Down     Status = "down"
+)
+
+func (s Status) worse(than Status) bool {
+	rank := map[Status]int{Up: 0, Degraded: 1, Down: 2}
+	return rank[s] > rank[than]
+}
+
+// Severity decides what a failing check does to the overall status
+type Severity int
+
+const (
+	// Critical failures mark the service down
+	Critical Severity = iota
+	// Warning failures only mark it degraded
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "critical"
+}
+
+// Check is one named component check. Run returns details to include in the
+// report, and an error if the component is unhealthy.
+type Check struct {
+	Name     string
+	Timeout  time.Duration
+	Severity Severity
+	Run      func(ctx context.Context) (map[string]any, error)
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Status   Status         `json:"status"`
+	Severity string         `json:"severity"`
+	Duration string         `json:"duration"`
+	Error    string         `json:"error,omitempty"`
+	Details  map[string]any `json:"details,omitempty"`
+}
+
+// Report is the combined outcome of every check
+type Report struct {
+	Status    Status            `json:"status"`
+	CheckedAt time.Time         `json:"checked_at"`
+	Checks    map[string]Result `json:"checks"`
+}
+
+// Checker runs a set of checks concurrently
+type Checker struct {
+	mu     sync.Mutex
+	checks []Check
+}
+
+// NewChecker creates a checker with the given checks
+func NewChecker(checks ...Check) *Checker {
+	return &Checker{checks: checks}
+}
+
+// Add registers another check
+func (c *Checker) Add(check Check) {
+	c.mu.Lock()
+	c.checks = append(c.checks, check)
+	c.mu.Unlock()
+}
+
+// Run executes every check, each under its own timeout
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := append([]Check(nil), c.checks...)
+	c.mu.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: Up, CheckedAt: time.Now(), Checks: make(map[string]Result, len(checks))}
+	for i, check := range checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status.worse(report.Status) {
+			report.Status = results[i].Status
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, check Check) (result Result) {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	result.Severity = check.Severity.String()
+
+	defer func() {
+		if p := recover(); p != nil {
+			result.Status = failedStatus(check.Severity)
+			result.Error = fmt.Sprintf("panic: %v", p)
+		}
+		result.Duration = time.Since(start).String()
+	}()
+
+	details, err := check.Run(ctx)
+	result.Details = details
+	if err != nil {
+		result.Status = failedStatus(check.Severity)
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = Up
+	return result
+}
+
+func failedStatus(severity Severity) Status {
+	if severity == Warning {
+		return Degraded
+	}
+	return Down
+}
+
+// Handler serves the report as JSON. It responds 503 when the overall status
+// is failAt or worse, so one Checker can back both probes:
+//
+//	mux.Handle("/livez", checker.Handler(health.Down))      // restart only when down
+//	mux.Handle("/readyz", checker.Handler(health.Degraded)) // stop traffic when degraded
+func (c *Checker) Handler(failAt Status) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+
+		code := http.StatusOK
+		if !failAt.worse(report.Status) {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,237 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// DefaultChecks returns every Postgres check with sensible thresholds.
+// Tweak Timeout/Severity on the returned values before passing them to
+// NewChecker if the defaults don't suit.
+func DefaultChecks(pool *database.Pool) []Check {
+	return []Check{
+		Connectivity(pool),
+		PoolSaturation(pool, 0.9),
+		Migrations(pool, 0),
+		ReplicationLag(pool, 30*time.Second),
+		Writable(pool),
+		LongTransactions(pool, 5*time.Minute),
+	}
+}
+
+// Connectivity runs SELECT 1
+func Connectivity(pool *database.Pool) Check {
+	return Check{
+		Name:     "connectivity",
+		Timeout:  2 * time.Second,
+		Severity: Critical,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			return nil, pool.HealthCheck(ctx)
+		},
+	}
+}
+
+// PoolSaturation fails when more than maxRatio of MaxConns are checked out
+func PoolSaturation(pool *database.Pool, maxRatio float64) Check {
+	return Check{
+		Name:     "pool_saturation",
+		Timeout:  time.Second,
+		Severity: Warning,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			stats := pool.Stats()
+			ratio := float64(stats.AcquiredConns) / float64(stats.MaxConns)
+			details := map[string]any{
+				"acquired_conns":      stats.AcquiredConns,
+				"max_conns":           stats.MaxConns,
+				"ratio":               ratio,
+				"empty_acquire_count": stats.EmptyAcquireCount,
+			}
+			if ratio > maxRatio {
+				return details, fmt.Errorf("pool %.0f%% saturated (limit %.0f%%)", ratio*100, maxRatio*100)
+			}
+			return details, nil
+		},
+	}
+}
+
+// Migrations reads golang-migrate's schema_migrations table. It fails when
+// the schema is dirty (a migration died halfway) or older than minVersion.
+func Migrations(pool *database.Pool, minVersion int64) Check {
+	return Check{
+		Name:     "migrations",
+		Timeout:  2 * time.Second,
+		Severity: Critical,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			var version int64
+			var dirty bool
+			err := pool.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, errors.New("no migrations applied")
+			}
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "42P01" {
+				return nil, errors.New("schema_migrations table missing")
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			details := map[string]any{"version": version, "dirty": dirty}
+			if dirty {
+				return details, fmt.Errorf("migration %d is dirty", version)
+			}
+			if version < minVersion {
+				return details, fmt.Errorf("schema version %d is older than required %d", version, minVersion)
+			}
+			return details, nil
+		},
+	}
+}
+
+// ReplicationLag fails when this node (if a replica) or any of its
+// streaming replicas (if a primary) is more than maxLag behind, and when a
+// replica's WAL receiver isn't streaming, since its lag is then unknown.
+func ReplicationLag(pool *database.Pool, maxLag time.Duration) Check {
+	return Check{
+		Name:     "replication_lag",
+		Timeout:  2 * time.Second,
+		Severity: Warning,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			state, err := database.QueryReplicationState(ctx, pool)
+			details := map[string]any{"role": role(state.InRecovery)}
+			if state.InRecovery {
+				details["wal_receiver"] = state.WALReceiver
+			}
+			if err != nil {
+				return details, err
+			}
+			if state.Lag == nil {
+				// Primary without replicas, or replica that hasn't replayed anything
+				return details, nil
+			}
+
+			details["lag"] = state.Lag.String()
+			if *state.Lag > maxLag {
+				return details, fmt.Errorf("replication lag %v exceeds %v", *state.Lag, maxLag)
+			}
+			return details, nil
+		},
+	}
+}
+
+// Writable fails when a primary can't take writes: it only accepts
+// read-only transactions (e.g. default_transaction_read_only was turned on)
+// or its disk is full (SQLSTATE 53100). The disk can only be caught by
+// writing, so each run upserts a row in health_probes (migration 008),
+// using one transaction ID. Hot standbys are read-only by design and pass
+// without writing.
+//
+// It is Critical: a primary that can't write can't do its job, so the
+// service is down rather than degraded.
+func Writable(pool *database.Pool) Check {
+	return Check{
+		Name:     "writable",
+		Timeout:  2 * time.Second,
+		Severity: Critical,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			var inRecovery bool
+			var defaultReadOnly, readOnly string
+			err := pool.QueryRow(ctx, `
+				SELECT
+					pg_is_in_recovery(),
+					current_setting('default_transaction_read_only'),
+					current_setting('transaction_read_only')
+			`).Scan(&inRecovery, &defaultReadOnly, &readOnly)
+			if err != nil {
+				return nil, err
+			}
+
+			details := map[string]any{
+				"role":                          role(inRecovery),
+				"default_transaction_read_only": defaultReadOnly,
+				"transaction_read_only":         readOnly,
+			}
+			if inRecovery {
+				return details, nil
+			}
+			if defaultReadOnly == "on" || readOnly == "on" {
+				return details, errors.New("primary is read-only")
+			}
+
+			_, err = pool.Exec(ctx, `
+				INSERT INTO health_probes (name) VALUES (current_setting('application_name'))
+				ON CONFLICT (name) DO UPDATE SET probed_at = NOW()
+			`)
+			var pgErr *pgconn.PgError
+			switch {
+			case err == nil:
+				return details, nil
+			case errors.As(err, &pgErr) && pgErr.Code == "53100":
+				return details, fmt.Errorf("disk full: %s", pgErr.Message)
+			case errors.As(err, &pgErr) && pgErr.Code == "25006":
+				return details, errors.New("primary is read-only")
+			case errors.As(err, &pgErr) && pgErr.Code == "42P01":
+				return details, errors.New("health_probes table missing")
+			default:
+				return details, fmt.Errorf("probe write failed: %w", err)
+			}
+		},
+	}
+}
+
+func role(inRecovery bool) string {
+	if inRecovery {
+		return "replica"
+	}
+	return "primary"
+}
+
+// LongTransactions fails when any transaction has been open longer than
+// maxAge; they hold back vacuum and often hold locks.
+func LongTransactions(pool *database.Pool, maxAge time.Duration) Check {
+	return Check{
+		Name:     "long_transactions",
+		Timeout:  2 * time.Second,
+		Severity: Warning,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			var count int
+			var oldestSeconds *float64
+			var oldestPID *int32
+			var oldestState *string
+			err := pool.QueryRow(ctx, `
+				WITH long AS (
+					SELECT pid, state, EXTRACT(EPOCH FROM now() - xact_start) AS age
+					FROM pg_stat_activity
+					WHERE xact_start IS NOT NULL
+						AND xact_start < now() - make_interval(secs => $1)
+						AND pid <> pg_backend_pid()
+				)
+				SELECT
+					(SELECT count(*) FROM long),
+					oldest.age, oldest.pid, oldest.state
+				FROM (SELECT 1) one
+				LEFT JOIN (SELECT * FROM long ORDER BY age DESC LIMIT 1) oldest ON true
+			`, maxAge.Seconds()).Scan(&count, &oldestSeconds, &oldestPID, &oldestState)
+			if err != nil {
+				return nil, err
+			}
+
+			details := map[string]any{"count": count}
+			if count == 0 {
+				return details, nil
+			}
+			details["oldest_age"] = time.Duration(*oldestSeconds * float64(time.Second)).Round(time.Second).String()
+			details["oldest_pid"] = *oldestPID
+			if oldestState != nil {
+				details["oldest_state"] = *oldestState
+			}
+			return details, fmt.Errorf("%d transactions open longer than %v", count, maxAge)
+		},
+	}
+}
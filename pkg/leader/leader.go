@@ -0,0 +1,204 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/lock"
+)
+
+const lockNamespace = "leader"
+
+// Config describes one candidate in an election
+type Config struct {
+	// Election is the lock name; candidates with the same Election compete
+	Election string
+	// ID identifies this candidate in logs (default hostname-pid)
+	ID string
+	// RetryInterval is how often a follower tries to take the lock
+	RetryInterval time.Duration
+	// HeartbeatInterval is how often the leader checks it still holds the
+	// lock. It is also the heartbeat's timeout, so a leader whose session
+	// died steps down within about twice this.
+	HeartbeatInterval time.Duration
+
+	// OnElected runs in its own goroutine when leadership is won. Its ctx is
+	// cancelled on step-down, and it must return promptly when that happens.
+	// Step-down waits up to HeartbeatInterval for it, then releases the lock
+	// anyway and logs an error, since another candidate may already lead.
+	// This candidate doesn't campaign again until it has returned.
+	OnElected func(ctx context.Context)
+	// OnRevoked runs once the lock is released, after OnElected has
+	// returned or the wait for it timed out
+	OnRevoked func()
+
+	Logger *slog.Logger
+}
+
+// DefaultConfig returns sensible defaults for an election
+func DefaultConfig(election string) Config {
+	host, _ := os.Hostname()
+	return Config{
+		Election:          election,
+		ID:                fmt.Sprintf("%s-%d", host, os.Getpid()),
+		RetryInterval:     5 * time.Second,
+		HeartbeatInterval: 2 * time.Second,
+	}
+}
+
+// Elector campaigns for leadership using a session-level advisory lock.
+// Postgres drops the lock when the leader's session ends, so a crashed or
+// disconnected leader is replaced by the next candidate to retry.
+type Elector struct {
+	locker *lock.Locker
+	cfg    Config
+	leader atomic.Bool
+
+	// lingering is closed when an OnElected that outlived its step-down
+	// returns; nil when none is running. Only Run's goroutine touches it.
+	lingering chan struct{}
+}
+
+// New creates an Elector; call Run to start campaigning
+func New(pool *database.Pool, cfg Config) (*Elector, error) {
+	if cfg.Election == "" {
+		return nil, errors.New("leader: election name is required")
+	}
+	defaults := DefaultConfig(cfg.Election)
+	if cfg.ID == "" {
+		cfg.ID = defaults.ID
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaults.RetryInterval
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = defaults.HeartbeatInterval
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	cfg.Logger = cfg.Logger.With("election", cfg.Election, "candidate", cfg.ID)
+
+	return &Elector{locker: lock.New(pool, lockNamespace), cfg: cfg}, nil
+}
+
+// Key returns the advisory lock key behind an election, for finding the
+// leader's backend in pg_locks
+func Key(election string) int64 {
+	return lock.Key(lockNamespace, election)
+}
+
+// IsLeader reports whether this candidate currently holds leadership
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns until ctx is cancelled, stepping down first if leading
+func (e *Elector) Run(ctx context.Context) error {
+	for {
+		if err := e.campaign(ctx); err != nil && ctx.Err() == nil {
+			e.cfg.Logger.Warn("leader election attempt failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.cfg.RetryInterval):
+		}
+	}
+}
+
+// campaign tries once for the lock and, if won, leads until the heartbeat
+// fails or ctx is cancelled.
+func (e *Elector) campaign(ctx context.Context) error {
+	if e.lingering != nil {
+		select {
+		case <-e.lingering:
+			e.lingering = nil
+		default:
+			return errors.New("previous OnElected still running; not campaigning")
+		}
+	}
+
+	// The lease must outlive ctx so the work is stopped before the lock is
+	// released, rather than the lease closing itself underneath it.
+	lease, err := e.locker.TryLock(context.WithoutCancel(ctx), e.cfg.Election)
+	if err != nil {
+		return err
+	}
+	if lease == nil {
+		return nil
+	}
+
+	e.leader.Store(true)
+	e.cfg.Logger.Info("elected leader")
+
+	workCtx, stopWork := context.WithCancel(ctx)
+	workDone := make(chan struct{})
+	if e.cfg.OnElected != nil {
+		go func() {
+			defer close(workDone)
+			e.cfg.OnElected(workCtx)
+		}()
+	} else {
+		close(workDone)
+	}
+
+	err = e.heartbeat(ctx, lease)
+
+	// Step down: stop claiming leadership and stop the work, give it a
+	// heartbeat to return, then drop the lock (a failed unlock closes the
+	// session, which drops it too) and tell the caller.
+	e.leader.Store(false)
+	stopWork()
+	select {
+	case <-workDone:
+	case <-time.After(e.cfg.HeartbeatInterval):
+		e.cfg.Logger.Error("OnElected still running after step-down; releasing leadership anyway",
+			"waited", e.cfg.HeartbeatInterval)
+		e.lingering = workDone
+	}
+	if closeErr := lease.Close(); closeErr != nil {
+		e.cfg.Logger.Warn("release leadership lock", "error", closeErr)
+	}
+	if ctx.Err() != nil {
+		e.cfg.Logger.Info("stepped down", "reason", "shutdown")
+	} else {
+		e.cfg.Logger.Warn("stepped down", "reason", err)
+	}
+	if e.cfg.OnRevoked != nil {
+		e.cfg.OnRevoked()
+	}
+	return nil
+}
+
+// heartbeat returns once leadership can no longer be vouched for: the
+// lock check fails or times out, the lock is gone, or ctx is cancelled.
+func (e *Elector) heartbeat(ctx context.Context, lease *lock.Lease) error {
+	ticker := time.NewTicker(e.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, e.cfg.HeartbeatInterval)
+		held, err := lease.Held(checkCtx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("heartbeat: %w", err)
+		}
+		if !held {
+			return errors.New("heartbeat: lock no longer held")
+		}
+	}
+}
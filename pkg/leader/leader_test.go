@@ -0,0 +1,178 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// testPool connects to PLAYGROUND_TEST_DATABASE_URL, skipping the test when
+// it isn't set
+func testPool(t *testing.T) *database.Pool {
+	t.Helper()
+	dsn := os.Getenv("PLAYGROUND_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("set PLAYGROUND_TEST_DATABASE_URL to run against a live server")
+	}
+	t.Setenv(database.ConfigFileEnv, "")
+	t.Setenv("DATABASE_URL", dsn)
+	cfg, err := database.LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.MinConns = 0
+	cfg.MaxConns = 4
+
+	pool, err := database.NewPool(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// leaderPID finds the backend holding an election's lock
+func leaderPID(ctx context.Context, pool *database.Pool, election string) (int32, error) {
+	var pid int32
+	err := pool.QueryRow(ctx, `
+		SELECT pid FROM pg_locks
+		WHERE locktype = 'advisory' AND objsubid = 1 AND granted
+			AND (classid::bigint << 32 | objid::bigint) = $1
+	`, Key(election)).Scan(&pid)
+	return pid, err
+}
+
+func TestFailoverOnTerminatedBackend(t *testing.T) {
+	admin := testPool(t)
+	election := fmt.Sprintf("test-failover-%d", time.Now().UnixNano())
+	const (
+		retry     = 200 * time.Millisecond
+		heartbeat = 300 * time.Millisecond
+	)
+
+	elected := make(chan string, 10)
+	revoked := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	electors := map[string]*Elector{}
+	done := make(chan struct{}, 2)
+	for _, id := range []string{"a", "b"} {
+		e, err := New(testPool(t), Config{
+			Election:          election,
+			ID:                id,
+			RetryInterval:     retry,
+			HeartbeatInterval: heartbeat,
+			OnElected:         func(context.Context) { elected <- id },
+			OnRevoked:         func() { revoked <- id },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		electors[id] = e
+		go func() {
+			e.Run(ctx)
+			done <- struct{}{}
+		}()
+	}
+	defer func() {
+		cancel()
+		<-done
+		<-done
+	}()
+
+	var first string
+	select {
+	case first = <-elected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nobody was elected")
+	}
+	if !electors[first].IsLeader() {
+		t.Fatalf("%s was elected but doesn't report leadership", first)
+	}
+
+	pid, err := leaderPID(ctx, admin, election)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Exec(ctx, "SELECT pg_terminate_backend($1)", pid); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old leader notices within two heartbeats; the follower takes the
+	// freed lock on its next retry
+	window := 2*heartbeat + retry + time.Second
+	deadline := time.After(window)
+	var gotRevoked, next string
+	for gotRevoked == "" || next == "" {
+		select {
+		case id := <-revoked:
+			gotRevoked = id
+		case id := <-elected:
+			next = id
+		case <-deadline:
+			t.Fatalf("within %v: revoked=%q elected=%q, want revoked=%q and the other elected", window, gotRevoked, next, first)
+		}
+	}
+	if gotRevoked != first {
+		t.Errorf("OnRevoked fired for %s, want the old leader %s", gotRevoked, first)
+	}
+	if next == first {
+		t.Errorf("%s was re-elected instead of the other candidate taking over", first)
+	}
+	if electors[first].IsLeader() {
+		t.Errorf("%s still reports leadership after its backend was terminated", first)
+	}
+}
+
+func TestStepDownDoesNotWaitForStuckWork(t *testing.T) {
+	pool := testPool(t)
+	const heartbeat = 300 * time.Millisecond
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+	elected := make(chan struct{}, 1)
+	revoked := make(chan struct{}, 1)
+	e, err := New(pool, Config{
+		Election:          fmt.Sprintf("test-stuck-%d", time.Now().UnixNano()),
+		RetryInterval:     100 * time.Millisecond,
+		HeartbeatInterval: heartbeat,
+		// Ignores its ctx
+		OnElected: func(context.Context) { elected <- struct{}{}; <-stuck },
+		OnRevoked: func() { revoked <- struct{}{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-elected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not elected")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(heartbeat + time.Second):
+		t.Fatal("step-down blocked on OnElected ignoring its ctx")
+	}
+	if e.IsLeader() {
+		t.Error("still reports leadership after stepping down")
+	}
+	select {
+	case <-revoked:
+	default:
+		t.Error("OnRevoked didn't run")
+	}
+}
@@ -0,0 +1,223 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// ErrNotAcquired is returned when a lock is held elsewhere past the timeout
+var ErrNotAcquired = errors.New("lock: not acquired")
+
+// releaseTimeout bounds the unlock round trip when a lease is closed
+const releaseTimeout = 5 * time.Second
+
+// Key hashes a namespaced resource name into an advisory lock key.
+// FNV-1a is stable across processes and Go versions, so every service
+// computing Key("fleet", "sweep") agrees on the same lock.
+func Key(namespace, name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(namespace))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// Locker hands out advisory locks for names within one namespace
+type Locker struct {
+	pool      *database.Pool
+	namespace string
+}
+
+// New creates a Locker; namespace keeps unrelated subsystems' names apart
+func New(pool *database.Pool, namespace string) *Locker {
+	return &Locker{pool: pool, namespace: namespace}
+}
+
+// Key returns the advisory lock key for name
+func (l *Locker) Key(name string) int64 {
+	return Key(l.namespace, name)
+}
+
+// Lock blocks until the session-level lock on name is held or ctx is done
+func (l *Locker) Lock(ctx context.Context, name string) (*Lease, error) {
+	return l.acquire(ctx, name, 0)
+}
+
+// LockTimeout waits up to timeout for the lock, then returns ErrNotAcquired
+func (l *Locker) LockTimeout(ctx context.Context, name string, timeout time.Duration) (*Lease, error) {
+	if timeout <= 0 {
+		return nil, errors.New("lock: timeout must be positive")
+	}
+	return l.acquire(ctx, name, timeout)
+}
+
+// TryLock takes the lock if it is free. A nil lease and nil error mean
+// someone else holds it.
+func (l *Locker) TryLock(ctx context.Context, name string) (*Lease, error) {
+	key := l.Key(name)
+	conn, err := l.pool.AcquireSession(ctx, "advisory locks")
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		discard(conn)
+		return nil, fmt.Errorf("try lock %q: %w", name, err)
+	}
+	if !acquired {
+		conn.Release()
+		return nil, nil
+	}
+	return newLease(ctx, conn, name, key), nil
+}
+
+// acquire waits for the lock with the server-side lock_timeout (0 = forever),
+// so a slow wait doesn't cost a cancelled connection. statement_timeout is
+// lifted too, or the session default would cut long waits short.
+func (l *Locker) acquire(ctx context.Context, name string, timeout time.Duration) (*Lease, error) {
+	key := l.Key(name)
+	conn, err := l.pool.AcquireSession(ctx, "advisory locks")
+	if err != nil {
+		return nil, err
+	}
+
+	// Session advisory locks survive the transaction; it only scopes SET LOCAL
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			SELECT set_config('lock_timeout', $1, true), set_config('statement_timeout', '0', true)
+		`, fmt.Sprintf("%dms", timeout.Milliseconds()))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "SELECT pg_advisory_lock($1)", key)
+		return err
+	})
+	if err != nil {
+		// Whether or not the lock was granted before the failure, closing the
+		// session guarantees we don't leak it.
+		discard(conn)
+		if isLockNotAvailable(err) {
+			return nil, fmt.Errorf("lock %q: %w", name, ErrNotAcquired)
+		}
+		return nil, fmt.Errorf("lock %q: %w", name, err)
+	}
+	return newLease(ctx, conn, name, key), nil
+}
+
+// Lease is a held session-level advisory lock pinned to its own connection.
+// Close it to release the lock and return the connection; cancelling the
+// context it was acquired with does the same.
+type Lease struct {
+	conn *pgxpool.Conn
+	name string
+	key  int64
+
+	mu     sync.Mutex
+	closed chan struct{}
+	err    error
+}
+
+func newLease(ctx context.Context, conn *pgxpool.Conn, name string, key int64) *Lease {
+	l := &Lease{conn: conn, name: name, key: key, closed: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = l.Close()
+		case <-l.closed:
+		}
+	}()
+	return l
+}
+
+// Name returns the resource name the lease locks
+func (l *Lease) Name() string { return l.name }
+
+// Key returns the advisory lock key
+func (l *Lease) Key() int64 { return l.key }
+
+// Done is closed once the lease has been released
+func (l *Lease) Done() <-chan struct{} { return l.closed }
+
+// Held asks the server whether this session still holds the lock. An error
+// usually means the connection is gone, and with it the lock.
+func (l *Lease) Held(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.closed:
+		return false, nil
+	default:
+	}
+
+	// pg_locks splits a bigint key into classid (high) and objid (low) with objsubid 1
+	var held bool
+	err := l.conn.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM pg_locks
+			WHERE locktype = 'advisory' AND objsubid = 1 AND granted
+				AND pid = pg_backend_pid()
+				AND (classid::bigint << 32 | objid::bigint) = $1
+		)
+	`, l.key).Scan(&held)
+	if err != nil {
+		return false, fmt.Errorf("check lock %q: %w", l.name, err)
+	}
+	return held, nil
+}
+
+// Close releases the lock and the connection. It is safe to call more than
+// once; later calls return the first call's result.
+func (l *Lease) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.closed:
+		return l.err
+	default:
+	}
+	defer close(l.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	var released bool
+	err := l.conn.QueryRow(ctx, "SELECT pg_advisory_unlock($1)", l.key).Scan(&released)
+	switch {
+	case err != nil:
+		discard(l.conn)
+		l.err = fmt.Errorf("unlock %q: %w", l.name, err)
+	case !released:
+		// The session no longer held it (e.g. the backend was restarted)
+		discard(l.conn)
+		l.err = fmt.Errorf("unlock %q: lock was not held", l.name)
+	default:
+		l.conn.Release()
+	}
+	return l.err
+}
+
+// discard closes the underlying session, dropping any locks it holds, and
+// returns the dead connection so the pool replaces it.
+func discard(conn *pgxpool.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	_ = conn.Conn().Close(ctx)
+	conn.Release()
+}
+
+func isLockNotAvailable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "55P03"
+}
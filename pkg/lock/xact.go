@@ -0,0 +1,59 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Transaction-level locks are released by COMMIT or ROLLBACK, so there is
+// no handle to close and no session to pin. They also work behind PgBouncer
+// in transaction mode.
+
+// LockTx blocks until the transaction holds the lock on name. It waits
+// under the session's lock_timeout, like any other lock in tx.
+func (l *Locker) LockTx(ctx context.Context, tx pgx.Tx, name string) error {
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", l.Key(name)); err != nil {
+		if isLockNotAvailable(err) {
+			return fmt.Errorf("lock %q: %w", name, ErrNotAcquired)
+		}
+		return fmt.Errorf("lock %q: %w", name, err)
+	}
+	return nil
+}
+
+// LockTxTimeout waits up to timeout for the lock, then returns
+// ErrNotAcquired. A failed wait aborts tx, as any lock_timeout error does.
+func (l *Locker) LockTxTimeout(ctx context.Context, tx pgx.Tx, name string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return errors.New("lock: timeout must be positive")
+	}
+
+	var previous string
+	err := tx.QueryRow(ctx, `
+		SELECT current_setting('lock_timeout'), set_config('lock_timeout', $1, true)
+	`, fmt.Sprintf("%dms", timeout.Milliseconds())).Scan(&previous, nil)
+	if err != nil {
+		return fmt.Errorf("set lock_timeout: %w", err)
+	}
+	if err := l.LockTx(ctx, tx, name); err != nil {
+		return err
+	}
+	// Put the caller's setting back for the rest of the transaction
+	if _, err := tx.Exec(ctx, "SELECT set_config('lock_timeout', $1, true)", previous); err != nil {
+		return fmt.Errorf("restore lock_timeout: %w", err)
+	}
+	return nil
+}
+
+// TryLockTx takes the lock for the rest of the transaction if it is free
+func (l *Locker) TryLockTx(ctx context.Context, tx pgx.Tx, name string) (bool, error) {
+	var acquired bool
+	if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", l.Key(name)).Scan(&acquired); err != nil {
+		return false, fmt.Errorf("try lock %q: %w", name, err)
+	}
+	return acquired, nil
+}
@@ -0,0 +1,275 @@
+package matview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/lock"
+)
+
+// ErrUnknownView is returned for a view that was never registered
+var ErrUnknownView = errors.New("matview: unknown view")
+
+// View declares a materialized view and how often it is refreshed
+type View struct {
+	Name         string        // optionally schema-qualified
+	Definition   string        // SELECT for CREATE MATERIALIZED VIEW; empty if the view is created elsewhere
+	UniqueKey    []string      // columns of the unique index REFRESH ... CONCURRENTLY needs
+	Interval     time.Duration // refresh this often
+	MaxStaleness time.Duration // older than this counts as stale (default 2 * Interval)
+}
+
+func (v View) ident() pgx.Identifier { return pgx.Identifier(strings.Split(v.Name, ".")) }
+
+// indexName is the unique index Ensure creates for UniqueKey
+func (v View) indexName() string {
+	name := v.Name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name + "_refresh_key"
+}
+
+// Config tunes a Registry
+type Config struct {
+	CheckInterval time.Duration // how often Run looks for views that are due
+	Logger        *slog.Logger
+}
+
+// DefaultConfig returns sensible defaults
+func DefaultConfig() Config {
+	return Config{CheckInterval: 10 * time.Second}
+}
+
+// Registry refreshes registered materialized views on schedule. Refreshes
+// take an advisory lock per view and schedules are kept in the
+// matview_refreshes table (migration 005), so any number of instances can
+// run the scheduler and each view is refreshed by one of them at a time.
+type Registry struct {
+	pool   *database.Pool
+	locker *lock.Locker
+	cfg    Config
+
+	mu    sync.RWMutex
+	views map[string]View
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry(pool *database.Pool, cfg Config) *Registry {
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = DefaultConfig().CheckInterval
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Registry{pool: pool, locker: lock.New(pool, "matview"), cfg: cfg, views: make(map[string]View)}
+}
+
+// Register adds or replaces a view. Call Ensure afterwards to create it.
+func (r *Registry) Register(v View) error {
+	switch {
+	case v.Name == "":
+		return errors.New("matview: view name is required")
+	case v.Interval <= 0:
+		return fmt.Errorf("matview %s: refresh interval must be positive", v.Name)
+	case len(v.UniqueKey) == 0:
+		return fmt.Errorf("matview %s: a unique key is required for concurrent refresh", v.Name)
+	}
+	if v.MaxStaleness <= 0 {
+		v.MaxStaleness = 2 * v.Interval
+	}
+	r.mu.Lock()
+	r.views[v.Name] = v
+	r.mu.Unlock()
+	return nil
+}
+
+// Views returns the registered views sorted by name
+func (r *Registry) Views() []View {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	views := make([]View, 0, len(r.views))
+	for _, v := range r.views {
+		views = append(views, v)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	return views
+}
+
+func (r *Registry) view(name string) (View, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v, ok := r.views[name]
+	if !ok {
+		return View{}, fmt.Errorf("%w %q", ErrUnknownView, name)
+	}
+	return v, nil
+}
+
+// Ensure creates each registered view (WITH NO DATA, if it has a
+// Definition and doesn't exist yet) and its unique index, and records its
+// schedule. A new view is populated by its first refresh.
+func (r *Registry) Ensure(ctx context.Context) error {
+	for _, v := range r.Views() {
+		err := database.WithTx(ctx, r.pool, func(tx pgx.Tx) error {
+			if v.Definition != "" {
+				_, err := tx.Exec(ctx, fmt.Sprintf("CREATE MATERIALIZED VIEW IF NOT EXISTS %s AS %s WITH NO DATA",
+					v.ident().Sanitize(), v.Definition))
+				if err != nil {
+					return fmt.Errorf("create view: %w", err)
+				}
+			}
+
+			cols := make([]string, len(v.UniqueKey))
+			for i, c := range v.UniqueKey {
+				cols[i] = pgx.Identifier{c}.Sanitize()
+			}
+			_, err := tx.Exec(ctx, fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)",
+				pgx.Identifier{v.indexName()}.Sanitize(), v.ident().Sanitize(), strings.Join(cols, ", ")))
+			if err != nil {
+				return fmt.Errorf("create unique index: %w", err)
+			}
+
+			_, err = tx.Exec(ctx, `
+				INSERT INTO matview_refreshes (view_name, refresh_interval)
+				VALUES ($1, make_interval(secs => $2))
+				ON CONFLICT (view_name) DO UPDATE SET refresh_interval = EXCLUDED.refresh_interval
+			`, v.Name, v.Interval.Seconds())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("ensure %s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// Refresh refreshes the view now, whether or not it is due. It returns
+// false if another instance is refreshing it already.
+func (r *Registry) Refresh(ctx context.Context, name string) (bool, error) {
+	v, err := r.view(name)
+	if err != nil {
+		return false, err
+	}
+	return r.refresh(ctx, v, true)
+}
+
+// RefreshDue refreshes every view whose interval has passed since its last
+// refresh started, and returns how many it refreshed.
+func (r *Registry) RefreshDue(ctx context.Context) (int, error) {
+	var refreshed int
+	var errs []error
+	for _, v := range r.Views() {
+		ok, err := r.refresh(ctx, v, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("refresh %s: %w", v.Name, err))
+		}
+		if ok {
+			refreshed++
+		}
+	}
+	return refreshed, errors.Join(errs...)
+}
+
+// Run refreshes views as they come due until ctx is cancelled
+func (r *Registry) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.RefreshDue(ctx); err != nil && ctx.Err() == nil {
+			r.cfg.Logger.Warn("matview refresh failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Registry) refresh(ctx context.Context, v View, force bool) (bool, error) {
+	lease, err := r.locker.TryLock(ctx, v.Name)
+	if err != nil {
+		return false, err
+	}
+	if lease == nil {
+		return false, nil
+	}
+	defer lease.Close()
+
+	// Checked under the lock, so an instance that just lost the race
+	// doesn't refresh again right after the winner.
+	if !force {
+		var due bool
+		err := r.pool.QueryRow(ctx, `
+			SELECT last_started_at IS NULL OR last_started_at + refresh_interval <= NOW()
+			FROM matview_refreshes WHERE view_name = $1
+		`, v.Name).Scan(&due)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, fmt.Errorf("%s has no schedule; call Ensure first", v.Name)
+		}
+		if err != nil || !due {
+			return false, err
+		}
+	}
+
+	_, err = r.pool.Exec(ctx, `
+		INSERT INTO matview_refreshes (view_name, refresh_interval, last_started_at)
+		VALUES ($1, make_interval(secs => $2), NOW())
+		ON CONFLICT (view_name) DO UPDATE SET last_started_at = NOW()
+	`, v.Name, v.Interval.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("record refresh start: %w", err)
+	}
+
+	start := time.Now()
+	refreshErr := database.WithTx(ctx, r.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+			return err
+		}
+		// CONCURRENTLY only works once the view holds data
+		var populated bool
+		err := tx.QueryRow(ctx, "SELECT relispopulated FROM pg_class WHERE oid = $1::regclass", v.Name).Scan(&populated)
+		if err != nil {
+			return err
+		}
+		sql := "REFRESH MATERIALIZED VIEW CONCURRENTLY " + v.ident().Sanitize()
+		if !populated {
+			sql = "REFRESH MATERIALIZED VIEW " + v.ident().Sanitize()
+		}
+		_, err = tx.Exec(ctx, sql)
+		return err
+	})
+	took := time.Since(start)
+
+	// Bookkeeping must land even if ctx was what ended the refresh
+	bg, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if refreshErr != nil {
+		_, err = r.pool.Exec(bg, `
+			UPDATE matview_refreshes
+			SET last_error = $2, last_duration = make_interval(secs => $3), failures = failures + 1
+			WHERE view_name = $1
+		`, v.Name, refreshErr.Error(), took.Seconds())
+		return false, errors.Join(refreshErr, err)
+	}
+	_, err = r.pool.Exec(bg, `
+		UPDATE matview_refreshes
+		SET last_success_at = NOW(), last_error = NULL, last_duration = make_interval(secs => $2),
+			refreshes = refreshes + 1
+		WHERE view_name = $1
+	`, v.Name, took.Seconds())
+	if err != nil {
+		return true, fmt.Errorf("record refresh: %w", err)
+	}
+	r.cfg.Logger.Debug("refreshed materialized view", "view", v.Name, "duration", took)
+	return true, nil
+}
@@ -0,0 +1,105 @@
+package matview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// Status is a view's refresh history as recorded in matview_refreshes
+type Status struct {
+	View         string
+	Interval     time.Duration
+	LastStarted  *time.Time
+	LastSuccess  *time.Time
+	LastDuration time.Duration
+	LastError    string
+	Refreshes    int64
+	Failures     int64
+	Age          time.Duration // since the last successful refresh; 0 if never refreshed
+	Stale        bool          // never refreshed, or Age is past MaxStaleness
+}
+
+const statusColumns = `
+	view_name,
+	EXTRACT(EPOCH FROM refresh_interval)::float8,
+	last_started_at,
+	last_success_at,
+	COALESCE(EXTRACT(EPOCH FROM last_duration)::float8, 0),
+	COALESCE(last_error, ''),
+	refreshes,
+	failures,
+	COALESCE(EXTRACT(EPOCH FROM NOW() - last_success_at)::float8, 0)
+`
+
+func scanStatus(row pgx.CollectableRow) (Status, error) {
+	var s Status
+	var interval, duration, age float64
+	err := row.Scan(&s.View, &interval, &s.LastStarted, &s.LastSuccess, &duration, &s.LastError,
+		&s.Refreshes, &s.Failures, &age)
+	s.Interval = seconds(interval)
+	s.LastDuration = seconds(duration)
+	s.Age = seconds(age)
+	return s, err
+}
+
+func seconds(f float64) time.Duration { return time.Duration(f * float64(time.Second)) }
+
+// Status reports a registered view's refresh history and whether it is stale
+func (r *Registry) Status(ctx context.Context, name string) (Status, error) {
+	v, err := r.view(name)
+	if err != nil {
+		return Status{}, err
+	}
+	rows, err := r.pool.Query(ctx, "SELECT "+statusColumns+" FROM matview_refreshes WHERE view_name = $1", name)
+	if err != nil {
+		return Status{}, fmt.Errorf("query status: %w", err)
+	}
+	s, err := pgx.CollectOneRow(rows, scanStatus)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Registered but never ensured or refreshed
+		return Status{View: name, Interval: v.Interval, Stale: true}, nil
+	}
+	if err != nil {
+		return Status{}, fmt.Errorf("query status: %w", err)
+	}
+	s.Stale = s.LastSuccess == nil || s.Age > v.MaxStaleness
+	return s, nil
+}
+
+// Statuses reports every registered view
+func (r *Registry) Statuses(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	for _, v := range r.Views() {
+		s, err := r.Status(ctx, v.Name)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Staleness returns how long ago a view was last refreshed successfully,
+// for readers that don't hold a Registry. ok is false if it never was.
+func Staleness(ctx context.Context, pool *database.Pool, name string) (age time.Duration, ok bool, err error) {
+	var secs *float64
+	err = pool.QueryRow(ctx, `
+		SELECT EXTRACT(EPOCH FROM NOW() - last_success_at)::float8
+		FROM matview_refreshes WHERE view_name = $1
+	`, name).Scan(&secs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("query staleness: %w", err)
+	}
+	if secs == nil {
+		return 0, false, nil
+	}
+	return seconds(*secs), true, nil
+}
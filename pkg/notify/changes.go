@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Channels fed by the notify_row_change() triggers (migration 002)
+const (
+	SiteChanges  = "site_changes"
+	AssetChanges = "asset_changes"
+)
+
+// Op is the statement that changed a row
+type Op string
+
+const (
+	Insert Op = "INSERT"
+	Update Op = "UPDATE"
+	Delete Op = "DELETE"
+)
+
+// ChangeEvent is the payload sent by notify_row_change(). Row is the new
+// row (the old one for DELETE). When the full payload would exceed NOTIFY's
+// 8000-byte limit only the ID is sent: Truncated is true, Row is nil and
+// Changed is empty, so re-read the row if you need it.
+type ChangeEvent[T any] struct {
+	Op        Op        `json:"op"`
+	Table     string    `json:"table"`
+	ID        string    `json:"id"`
+	Changed   []string  `json:"changed"` // UPDATE only; includes updated_at
+	Row       *T        `json:"row"`
+	Truncated bool      `json:"truncated"`
+	At        time.Time `json:"at"`
+}
+
+// Has reports whether an UPDATE changed column
+func (e ChangeEvent[T]) Has(column string) bool {
+	return slices.Contains(e.Changed, column)
+}
+
+// Site is a sites row as rendered by to_jsonb
+type Site struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Address     string          `json:"address"`
+	City        string          `json:"city"`
+	Country     string          `json:"country"`
+	Coordinates *string         `json:"coordinates"` // POINT as "(x,y)"
+	Metadata    json.RawMessage `json:"metadata"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// Asset is an assets row as rendered by to_jsonb
+type Asset struct {
+	ID              string          `json:"id"`
+	SiteID          string          `json:"site_id"`
+	MacAddress      string          `json:"mac_address"`
+	SerialNumber    string          `json:"serial_number"`
+	AssetType       string          `json:"asset_type"`
+	Manufacturer    *string         `json:"manufacturer"`
+	Model           *string         `json:"model"`
+	FirmwareVersion *string         `json:"firmware_version"`
+	Status          string          `json:"status"`
+	Config          json.RawMessage `json:"config"`
+	Telemetry       json.RawMessage `json:"telemetry"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	LastSeen        time.Time       `json:"last_seen"`
+}
+
+type (
+	SiteEvent  = ChangeEvent[Site]
+	AssetEvent = ChangeEvent[Asset]
+)
+
+// DecodeSiteEvent decodes a site_changes notification
+func DecodeSiteEvent(ev Event) (SiteEvent, error) {
+	return decodeChange[Site](ev, "sites")
+}
+
+// DecodeAssetEvent decodes an asset_changes notification
+func DecodeAssetEvent(ev Event) (AssetEvent, error) {
+	return decodeChange[Asset](ev, "assets")
+}
+
+func decodeChange[T any](ev Event, table string) (ChangeEvent[T], error) {
+	if ev.Missed {
+		return ChangeEvent[T]{}, fmt.Errorf("decode %s: missed-notifications marker has no payload", ev.Channel)
+	}
+	change, err := DecodeJSON[ChangeEvent[T]](ev)
+	if err != nil {
+		return change, err
+	}
+	if change.Table != table {
+		return change, fmt.Errorf("decode %s: payload is for table %q, want %q", ev.Channel, change.Table, table)
+	}
+	return change, nil
+}
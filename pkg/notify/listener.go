@@ -0,0 +1,352 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// Options tunes a Listener
+type Options struct {
+	BufferSize   int           // events buffered per subscription
+	SlowConsumer Policy        // what to do when a buffer is full
+	PingInterval time.Duration // idle time before checking the connection is alive
+	MinBackoff   time.Duration // first reconnect delay
+	MaxBackoff   time.Duration // cap on reconnect delay
+	Logger       *slog.Logger
+}
+
+// DefaultOptions returns sensible defaults
+func DefaultOptions() Options {
+	return Options{
+		BufferSize:   64,
+		SlowConsumer: DropOldest,
+		PingInterval: 30 * time.Second,
+		MinBackoff:   100 * time.Millisecond,
+		MaxBackoff:   30 * time.Second,
+	}
+}
+
+// Listener owns a dedicated connection for LISTEN and fans notifications
+// out to subscribers. If the connection drops it reconnects with backoff,
+// re-issues LISTEN for every subscribed channel and sends each subscriber
+// a Missed event.
+type Listener struct {
+	cfg  *database.Config
+	opts Options
+
+	mu        sync.Mutex
+	subs      map[string]map[*Subscription]struct{}
+	listening map[string]bool // channels LISTENed on the current connection
+	dirty     bool            // subs changed since the last LISTEN/UNLISTEN pass
+	interrupt context.CancelFunc
+	stopped   bool
+}
+
+// NewListener creates a listener for cfg's database; call Run to connect.
+// LISTEN needs a real session, so it refuses PgBouncer configs.
+func NewListener(cfg *database.Config, opts Options) (*Listener, error) {
+	if cfg.PgBouncer {
+		return nil, fmt.Errorf("LISTEN: %w", database.ErrSessionUnavailable)
+	}
+	defaults := DefaultOptions()
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaults.BufferSize
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = defaults.PingInterval
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = defaults.MinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(defaults.MaxBackoff, opts.MinBackoff)
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	return &Listener{
+		cfg:       cfg,
+		opts:      opts,
+		subs:      make(map[string]map[*Subscription]struct{}),
+		listening: make(map[string]bool),
+	}, nil
+}
+
+// Subscribe registers for notifications on channel and returns once the
+// LISTEN is active, so anything NOTIFYed afterwards will be delivered. It
+// waits for Run to (re)connect if needed.
+func (l *Listener) Subscribe(ctx context.Context, channel string) (*Subscription, error) {
+	ch := make(chan Event, l.opts.BufferSize)
+	sub := &Subscription{
+		C:        ch,
+		listener: l,
+		channel:  channel,
+		ch:       ch,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if l.subs[channel] == nil {
+		l.subs[channel] = make(map[*Subscription]struct{})
+	}
+	l.subs[channel][sub] = struct{}{}
+	if l.listening[channel] {
+		close(sub.ready)
+	} else {
+		l.changed()
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-sub.ready:
+		return sub, nil
+	case <-sub.done:
+		return nil, sub.Err()
+	case <-ctx.Done():
+		sub.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Run keeps the connection up until ctx is cancelled, then closes every
+// subscription. Call it once.
+func (l *Listener) Run(ctx context.Context) error {
+	defer l.stop()
+
+	connected := false
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			delay := l.backoff(attempt)
+			l.opts.Logger.Warn("notify listener reconnecting", "attempt", attempt, "delay", delay)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		conn, err := l.connect(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			l.opts.Logger.Warn("notify listener connect failed", "error", err)
+			continue
+		}
+
+		err = l.serve(ctx, conn, connected)
+		closeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = conn.Close(closeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l.opts.Logger.Warn("notify listener connection lost", "error", err)
+		connected = true
+		attempt = 0
+	}
+}
+
+func (l *Listener) connect(ctx context.Context) (*pgx.Conn, error) {
+	connConfig, err := l.cfg.ConnConfig()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	if err := l.cfg.ApplySession(ctx, conn); err != nil {
+		_ = conn.Close(ctx)
+		return nil, fmt.Errorf("apply session settings: %w", err)
+	}
+	return conn, nil
+}
+
+// serve listens on conn until it fails or ctx is cancelled. Subscribe and
+// Close interrupt the wait so LISTEN/UNLISTEN happen promptly; an idle wait
+// times out after PingInterval to check the connection isn't half-open.
+func (l *Listener) serve(ctx context.Context, conn *pgx.Conn, reconnected bool) error {
+	l.mu.Lock()
+	l.listening = make(map[string]bool)
+	l.dirty = true
+	l.mu.Unlock()
+
+	if err := l.sync(ctx, conn); err != nil {
+		return err
+	}
+	if reconnected {
+		l.mu.Lock()
+		for channel, subs := range l.subs {
+			for sub := range subs {
+				sub.deliver(Event{Channel: channel, Missed: true}, l.opts.SlowConsumer)
+			}
+		}
+		l.mu.Unlock()
+	}
+
+	for {
+		l.mu.Lock()
+		if l.dirty {
+			l.mu.Unlock()
+			if err := l.sync(ctx, conn); err != nil {
+				return err
+			}
+			continue
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, l.opts.PingInterval)
+		l.interrupt = cancel
+		l.mu.Unlock()
+
+		n, err := conn.WaitForNotification(waitCtx)
+
+		l.mu.Lock()
+		l.interrupt = nil
+		l.mu.Unlock()
+		waitErr := waitCtx.Err()
+		cancel()
+
+		switch {
+		case err == nil:
+			l.dispatch(n)
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.Is(waitErr, context.DeadlineExceeded):
+			pingCtx, cancel := context.WithTimeout(ctx, l.opts.PingInterval)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("ping: %w", err)
+			}
+		case waitErr != nil:
+			// Interrupted by Subscribe/Close; loop round to sync
+		default:
+			return err
+		}
+	}
+}
+
+// sync issues LISTEN/UNLISTEN so the connection matches the subscribed
+// channels, then releases subscribers waiting on their LISTEN.
+func (l *Listener) sync(ctx context.Context, conn *pgx.Conn) error {
+	l.mu.Lock()
+	l.dirty = false
+	var listen, unlisten []string
+	for channel, subs := range l.subs {
+		if len(subs) > 0 && !l.listening[channel] {
+			listen = append(listen, channel)
+		}
+	}
+	for channel := range l.listening {
+		if len(l.subs[channel]) == 0 {
+			unlisten = append(unlisten, channel)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, channel := range listen {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return fmt.Errorf("listen %s: %w", channel, err)
+		}
+	}
+	for _, channel := range unlisten {
+		if _, err := conn.Exec(ctx, "UNLISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return fmt.Errorf("unlisten %s: %w", channel, err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, channel := range listen {
+		l.listening[channel] = true
+	}
+	for _, channel := range unlisten {
+		delete(l.listening, channel)
+	}
+	for channel := range l.listening {
+		for sub := range l.subs[channel] {
+			select {
+			case <-sub.ready:
+			default:
+				close(sub.ready)
+			}
+		}
+	}
+	return nil
+}
+
+func (l *Listener) dispatch(n *pgconn.Notification) {
+	ev := Event{Channel: n.Channel, Payload: n.Payload, PID: n.PID}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for sub := range l.subs[n.Channel] {
+		sub.deliver(ev, l.opts.SlowConsumer)
+	}
+}
+
+// changed marks the subscriptions dirty and wakes the run loop.
+// Called with mu held.
+func (l *Listener) changed() {
+	l.dirty = true
+	if l.interrupt != nil {
+		l.interrupt()
+	}
+}
+
+// remove drops sub and closes its channel, recording err for Err.
+// Called with mu held.
+func (l *Listener) remove(sub *Subscription, err error) {
+	select {
+	case <-sub.done:
+		return
+	default:
+	}
+	delete(l.subs[sub.channel], sub)
+	if len(l.subs[sub.channel]) == 0 {
+		delete(l.subs, sub.channel)
+		l.changed()
+	}
+	sub.err = err
+	close(sub.ch)
+	close(sub.done)
+}
+
+// stop closes every subscription once Run returns
+func (l *Listener) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopped = true
+	for _, subs := range l.subs {
+		for sub := range subs {
+			l.remove(sub, ErrClosed)
+		}
+	}
+}
+
+// backoff returns a jittered exponential delay for the given attempt
+func (l *Listener) backoff(attempt int) time.Duration {
+	delay := l.opts.MinBackoff
+	for i := 1; i < attempt && delay < l.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > l.opts.MaxBackoff {
+		delay = l.opts.MaxBackoff
+	}
+	// Keep at least half the delay so a flapping server isn't hammered
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
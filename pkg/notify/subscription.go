@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var (
+	// ErrClosed is returned once the listener has stopped
+	ErrClosed = errors.New("notify: listener closed")
+	// ErrSlowConsumer closes a subscription that fell behind under the Disconnect policy
+	ErrSlowConsumer = errors.New("notify: subscriber too slow")
+)
+
+// Event is one notification, or a marker that notifications may have been missed
+type Event struct {
+	Channel string
+	Payload string
+	PID     uint32 // backend that sent the NOTIFY
+
+	// Missed is set on the event sent after every reconnect: anything
+	// NOTIFYed while the connection was down is gone, so resync from the
+	// tables. Payload is empty.
+	Missed bool
+}
+
+// DecodeJSON unmarshals a JSON payload into T
+func DecodeJSON[T any](ev Event) (T, error) {
+	var v T
+	if err := json.Unmarshal([]byte(ev.Payload), &v); err != nil {
+		return v, fmt.Errorf("decode %s payload: %w", ev.Channel, err)
+	}
+	return v, nil
+}
+
+// Policy decides what happens when a subscriber's buffer is full
+type Policy int
+
+const (
+	// DropNewest discards the incoming notification
+	DropNewest Policy = iota
+	// DropOldest discards the oldest buffered notification to make room
+	DropOldest
+	// Disconnect closes the subscription with ErrSlowConsumer
+	Disconnect
+)
+
+// Subscription receives events for one channel on C. C is closed when the
+// subscription is closed, the listener stops, or (under Disconnect) the
+// subscriber falls behind; Err says which.
+type Subscription struct {
+	C <-chan Event
+
+	listener *Listener
+	channel  string
+	ch       chan Event
+	ready    chan struct{} // closed once LISTEN is active for channel
+	done     chan struct{}
+	err      error // guarded by listener.mu
+	dropped  atomic.Uint64
+}
+
+// Channel returns the subscribed channel name
+func (s *Subscription) Channel() string { return s.channel }
+
+// Dropped counts notifications discarded because the buffer was full
+func (s *Subscription) Dropped() uint64 { return s.dropped.Load() }
+
+// Err returns why the subscription ended, or nil while it is open or after Close
+func (s *Subscription) Err() error {
+	s.listener.mu.Lock()
+	defer s.listener.mu.Unlock()
+	return s.err
+}
+
+// Close unsubscribes; the channel is UNLISTENed once nobody wants it
+func (s *Subscription) Close() {
+	s.listener.mu.Lock()
+	defer s.listener.mu.Unlock()
+	s.listener.remove(s, nil)
+}
+
+// deliver hands ev to the subscriber without blocking the listener.
+// Missed markers are never dropped: the oldest buffered event goes instead.
+// Called with listener.mu held.
+func (s *Subscription) deliver(ev Event, policy Policy) {
+	select {
+	case s.ch <- ev:
+		return
+	default:
+	}
+
+	if ev.Missed {
+		policy = DropOldest
+	}
+	switch policy {
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- ev:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	case Disconnect:
+		s.listener.remove(s, ErrSlowConsumer)
+	default:
+		s.dropped.Add(1)
+	}
+}
@@ -0,0 +1,62 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Message is an event to publish once the surrounding transaction commits
+type Message struct {
+	Topic   string
+	Key     string // e.g. the asset ID; empty for none
+	Payload any    // marshalled to JSON; json.RawMessage is stored as-is
+	Headers map[string]string
+}
+
+// Record is an outbox row handed to a Sink
+type Record struct {
+	ID        int64             `json:"id"`
+	Topic     string            `json:"topic"`
+	Key       string            `json:"key,omitempty"`
+	Payload   json.RawMessage   `json:"payload"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Attempts  int               `json:"attempts"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Insert writes msg to the outbox in tx, typically the one from
+// database.WithTx, so the event exists if and only if the change commits.
+func Insert(ctx context.Context, tx pgx.Tx, msg Message) (int64, error) {
+	if msg.Topic == "" {
+		return 0, errors.New("outbox: topic is required")
+	}
+	payload, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return 0, fmt.Errorf("marshal outbox payload: %w", err)
+	}
+	headers := msg.Headers
+	if headers == nil {
+		headers = map[string]string{}
+	}
+
+	var key *string
+	if msg.Key != "" {
+		key = &msg.Key
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO outbox (topic, key, payload, headers)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, msg.Topic, key, payload, headers).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert outbox message: %w", err)
+	}
+	return id, nil
+}
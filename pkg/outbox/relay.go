@@ -0,0 +1,229 @@
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// RelayConfig tunes a Relay
+type RelayConfig struct {
+	BatchSize    int           // rows claimed at once
+	PollInterval time.Duration // wait after finding nothing to send
+	SendTimeout  time.Duration // per-record deadline for Sink.Send
+	LeaseTimeout time.Duration // claimed rows stay hidden from other relays this long
+	Archive      bool          // move delivered rows to outbox_archive instead of deleting
+	RetryDelay   time.Duration // backoff after the first failed send
+	MaxDelay     time.Duration // cap on backoff
+	Logger       *slog.Logger
+}
+
+// DefaultRelayConfig returns sensible defaults
+func DefaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		BatchSize:    100,
+		PollInterval: time.Second,
+		SendTimeout:  10 * time.Second,
+		LeaseTimeout: 2 * time.Minute,
+		RetryDelay:   time.Second,
+		MaxDelay:     5 * time.Minute,
+	}
+}
+
+// Relay moves outbox rows to a Sink. A batch is claimed in one short
+// statement that leases the rows by pushing next_attempt_at past the
+// lease, so several relays can run side by side without holding locks or a
+// transaction open while sending. Each row is deleted (or archived) after
+// Send succeeds. A relay that dies mid-batch leaves its rows to be claimed
+// again once the lease runs out, and a crash between Send and the delete
+// resends the row: delivery is at-least-once.
+//
+// Rows with the same key are delivered in ID order, across batches and
+// relays: only the oldest undelivered row of a key is ever claimed, so a
+// failed send holds back the rest of that key until it succeeds.
+type Relay struct {
+	pool *database.Pool
+	sink Sink
+	cfg  RelayConfig
+}
+
+// NewRelay creates a relay delivering to sink
+func NewRelay(pool *database.Pool, sink Sink, cfg RelayConfig) *Relay {
+	defaults := DefaultRelayConfig()
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaults.PollInterval
+	}
+	if cfg.SendTimeout <= 0 {
+		cfg.SendTimeout = defaults.SendTimeout
+	}
+	if cfg.LeaseTimeout < 2*cfg.SendTimeout {
+		cfg.LeaseTimeout = max(defaults.LeaseTimeout, 2*cfg.SendTimeout)
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaults.RetryDelay
+	}
+	if cfg.MaxDelay < cfg.RetryDelay {
+		cfg.MaxDelay = max(defaults.MaxDelay, cfg.RetryDelay)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Relay{pool: pool, sink: sink, cfg: cfg}
+}
+
+// Run relays batches until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		sent, err := r.RelayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.cfg.Logger.Warn("outbox relay batch failed", "error", err)
+		}
+		if sent > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.cfg.PollInterval):
+		}
+	}
+}
+
+// RelayBatch claims up to BatchSize due rows, sends them and removes the
+// delivered ones. Claiming, removing and recording a failure are each their
+// own statement; no transaction stays open across a send. Rows the lease
+// won't cover any more, or left over when ctx is cancelled, are handed back
+// unsent. It returns how many were delivered.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	leaseEnd := time.Now().Add(r.cfg.LeaseTimeout)
+	records, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Bookkeeping must land even while shutting down
+	bg := context.WithoutCancel(ctx)
+
+	var sent int
+	for i, rec := range records {
+		if ctx.Err() != nil || time.Now().Add(r.cfg.SendTimeout).After(leaseEnd) {
+			return sent, r.release(bg, records[i:])
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, r.cfg.SendTimeout)
+		err := r.sink.Send(sendCtx, rec)
+		cancel()
+		if err == nil {
+			if err := r.remove(bg, rec.ID); err != nil {
+				return sent, errors.Join(err, r.release(bg, records[i+1:]))
+			}
+			sent++
+			continue
+		}
+
+		delay := r.backoff(rec.Attempts + 1)
+		r.cfg.Logger.Warn("outbox send failed",
+			"id", rec.ID, "topic", rec.Topic, "attempts", rec.Attempts+1, "retry_in", delay, "error", err)
+		_, err = r.pool.Exec(bg, `
+			UPDATE outbox
+			SET attempts = attempts + 1,
+				last_error = $2,
+				next_attempt_at = NOW() + make_interval(secs => $3)
+			WHERE id = $1
+		`, rec.ID, err.Error(), delay.Seconds())
+		if err != nil {
+			return sent, errors.Join(fmt.Errorf("record outbox failure: %w", err), r.release(bg, records[i+1:]))
+		}
+	}
+	return sent, nil
+}
+
+// claim leases up to BatchSize due rows, skipping any row with an earlier
+// undelivered row for the same key. That earlier row may be waiting for a
+// retry or leased by another relay; either way this one has to wait.
+func (r *Relay) claim(ctx context.Context) ([]Record, error) {
+	rows, err := r.pool.Query(ctx, `
+		UPDATE outbox
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM outbox o
+			WHERE next_attempt_at <= NOW()
+				AND (key IS NULL OR NOT EXISTS (
+					SELECT 1 FROM outbox earlier
+					WHERE earlier.key = o.key AND earlier.id < o.id
+				))
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, topic, COALESCE(key, ''), payload, headers, attempts, created_at
+	`, r.cfg.BatchSize, r.cfg.LeaseTimeout.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim outbox rows: %w", err)
+	}
+	records, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Record, error) {
+		var rec Record
+		err := row.Scan(&rec.ID, &rec.Topic, &rec.Key, &rec.Payload, &rec.Headers, &rec.Attempts, &rec.CreatedAt)
+		return rec, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("claim outbox rows: %w", err)
+	}
+	// RETURNING doesn't keep the subquery's order
+	slices.SortFunc(records, func(a, b Record) int { return cmp.Compare(a.ID, b.ID) })
+	return records, nil
+}
+
+// release ends the lease on records that weren't sent, so they are due again
+func (r *Relay) release(ctx context.Context, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(records))
+	for i, rec := range records {
+		ids[i] = rec.ID
+	}
+	if _, err := r.pool.Exec(ctx, "UPDATE outbox SET next_attempt_at = NOW() WHERE id = ANY($1)", ids); err != nil {
+		return fmt.Errorf("release outbox rows: %w", err)
+	}
+	return nil
+}
+
+func (r *Relay) remove(ctx context.Context, id int64) error {
+	var err error
+	if r.cfg.Archive {
+		_, err = r.pool.Exec(ctx, `
+			WITH delivered AS (
+				DELETE FROM outbox WHERE id = $1 RETURNING *
+			)
+			INSERT INTO outbox_archive (id, topic, key, payload, headers, attempts, created_at)
+			SELECT id, topic, key, payload, headers, attempts + 1, created_at FROM delivered
+		`, id)
+	} else {
+		_, err = r.pool.Exec(ctx, "DELETE FROM outbox WHERE id = $1", id)
+	}
+	if err != nil {
+		return fmt.Errorf("remove delivered outbox row %d: %w", id, err)
+	}
+	return nil
+}
+
+// backoff doubles RetryDelay per attempt up to MaxDelay
+func (r *Relay) backoff(attempt int) time.Duration {
+	delay := r.cfg.RetryDelay
+	for i := 1; i < attempt && delay < r.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, r.cfg.MaxDelay)
+}
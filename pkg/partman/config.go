@@ -0,0 +1,163 @@
+package partman
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Interval is the width of each partition
+type Interval string
+
+const (
+	Daily   Interval = "daily"
+	Weekly  Interval = "weekly" // ISO weeks, starting Monday
+	Monthly Interval = "monthly"
+)
+
+// start truncates t (in UTC) to the beginning of its partition
+func (i Interval) start(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch i {
+	case Weekly:
+		offset := (int(day.Weekday()) + 6) % 7 // days since Monday
+		return day.AddDate(0, 0, -offset)
+	case Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// next returns the start of the partition after the one starting at t
+func (i Interval) next(t time.Time) time.Time {
+	switch i {
+	case Weekly:
+		return t.AddDate(0, 0, 7)
+	case Monthly:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// suffix names the partition starting at t, e.g. telemetry_data_2024_01
+func (i Interval) suffix(t time.Time) string {
+	if i == Monthly {
+		return t.Format("2006_01")
+	}
+	return t.Format("2006_01_02")
+}
+
+func (i Interval) valid() bool {
+	return i == Daily || i == Weekly || i == Monthly
+}
+
+// ExpirePolicy decides what happens to partitions past the retention window
+type ExpirePolicy string
+
+const (
+	Drop    ExpirePolicy = "drop"    // detach and drop
+	Detach  ExpirePolicy = "detach"  // detach and keep as a plain table
+	Archive ExpirePolicy = "archive" // detach and move to ArchiveSchema
+)
+
+// TableConfig describes how one range-partitioned table is maintained
+type TableConfig struct {
+	Table            string        `yaml:"table" toml:"table"` // parent table, optionally schema-qualified
+	Interval         Interval      `yaml:"interval" toml:"interval"`
+	Premake          *int          `yaml:"premake" toml:"premake"`     // future partitions kept ahead of now; nil for the default, 0 for none
+	Retention        time.Duration `yaml:"retention" toml:"retention"` // 0 keeps everything
+	Expire           ExpirePolicy  `yaml:"expire" toml:"expire"`
+	ArchiveSchema    string        `yaml:"archive_schema" toml:"archive_schema"`
+	DefaultPartition bool          `yaml:"default_partition" toml:"default_partition"`
+}
+
+// DefaultTableConfig returns monthly partitions, three months ahead, kept forever
+func DefaultTableConfig(table string) TableConfig {
+	premake := 3
+	return TableConfig{
+		Table:         table,
+		Interval:      Monthly,
+		Premake:       &premake,
+		Expire:        Drop,
+		ArchiveSchema: "archive",
+	}
+}
+
+// withDefaults fills zero fields and validates the rest
+func (tc TableConfig) withDefaults() (TableConfig, error) {
+	defaults := DefaultTableConfig(tc.Table)
+	if tc.Table == "" {
+		return tc, fmt.Errorf("partman: table is required")
+	}
+	if tc.Interval == "" {
+		tc.Interval = defaults.Interval
+	}
+	if !tc.Interval.valid() {
+		return tc, fmt.Errorf("partman %s: unknown interval %q (daily, weekly, monthly)", tc.Table, tc.Interval)
+	}
+	if tc.Premake == nil {
+		tc.Premake = defaults.Premake
+	}
+	if *tc.Premake < 0 {
+		return tc, fmt.Errorf("partman %s: premake %d is negative", tc.Table, *tc.Premake)
+	}
+	if tc.Expire == "" {
+		tc.Expire = defaults.Expire
+	}
+	if tc.Expire != Drop && tc.Expire != Detach && tc.Expire != Archive {
+		return tc, fmt.Errorf("partman %s: unknown expire policy %q (drop, detach, archive)", tc.Table, tc.Expire)
+	}
+	if tc.ArchiveSchema == "" {
+		tc.ArchiveSchema = defaults.ArchiveSchema
+	}
+	return tc, nil
+}
+
+// tablesFile is the on-disk shape of a partman config
+type tablesFile struct {
+	Tables []TableConfig `yaml:"tables" toml:"tables"`
+}
+
+// LoadTables reads table configs from a YAML or TOML file:
+//
+//	tables:
+//	  - table: telemetry_data
+//	    interval: monthly
+//	    premake: 3         # 0 creates only the current partition
+//	    retention: 2160h   # 90 days
+//	    expire: archive
+//	    default_partition: true
+func LoadTables(path string) ([]TableConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read partman config: %w", err)
+	}
+
+	var tf tablesFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tf)
+	case ".toml":
+		err = toml.Unmarshal(data, &tf)
+	default:
+		return nil, fmt.Errorf("unsupported partman config format %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse partman config: %w", err)
+	}
+
+	for i, tc := range tf.Tables {
+		if tf.Tables[i], err = tc.withDefaults(); err != nil {
+			return nil, err
+		}
+	}
+	return tf.Tables, nil
+}
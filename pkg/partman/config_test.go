@@ -0,0 +1,56 @@
+package partman
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTablesPremake(t *testing.T) {
+	for _, tt := range []struct {
+		name, file string
+	}{
+		{"tables.yaml", `
+tables:
+  - table: unset
+  - table: none
+    premake: 0
+  - table: two
+    premake: 2
+`},
+		{"tables.toml", `
+[[tables]]
+table = "unset"
+[[tables]]
+table = "none"
+premake = 0
+[[tables]]
+table = "two"
+premake = 2
+`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			tables, err := LoadTables(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]int{"unset": 3, "none": 0, "two": 2}
+			for _, tc := range tables {
+				if *tc.Premake != want[tc.Table] {
+					t.Errorf("%s: premake %d, want %d", tc.Table, *tc.Premake, want[tc.Table])
+				}
+			}
+		})
+	}
+}
+
+func TestNegativePremakeRejected(t *testing.T) {
+	premake := -1
+	if _, err := (TableConfig{Table: "t", Premake: &premake}).withDefaults(); err == nil {
+		t.Error("negative premake accepted")
+	}
+}
@@ -0,0 +1,170 @@
+package partman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// partition is one existing child of the parent table
+type partition struct {
+	schema, name string
+	from, to     time.Time // zero from = MINVALUE, zero to = MAXVALUE
+	isDefault    bool
+}
+
+func (p partition) ident() pgx.Identifier { return pgx.Identifier{p.schema, p.name} }
+
+// overlaps reports whether p's range intersects [from, to)
+func (p partition) overlaps(from, to time.Time) bool {
+	startsBeforeEnd := p.from.IsZero() || p.from.Before(to)
+	endsAfterStart := p.to.IsZero() || p.to.After(from)
+	return startsBeforeEnd && endsAfterStart
+}
+
+// tableInfo is what Plan needs to know about a partitioned table
+type tableInfo struct {
+	schema, name string
+	keyColumn    string
+	keyType      string // format_type of the key, e.g. "timestamp with time zone"
+	columns      []string
+	partitions   []partition
+	defaultPart  *partition
+}
+
+func (t *tableInfo) ident() pgx.Identifier { return pgx.Identifier{t.schema, t.name} }
+
+// literal renders a partition bound for the key's type
+func (t *tableInfo) literal(ts time.Time) string {
+	switch t.keyType {
+	case "date":
+		return ts.Format("2006-01-02")
+	case "timestamp without time zone":
+		return ts.Format("2006-01-02 15:04:05")
+	default:
+		return ts.Format("2006-01-02 15:04:05-07")
+	}
+}
+
+var boundRe = regexp.MustCompile(`^FOR VALUES FROM \((.+)\) TO \((.+)\)$`)
+
+// boundLayouts covers how pg_get_expr prints date/timestamp bounds with TimeZone = UTC
+var boundLayouts = []string{
+	"2006-01-02 15:04:05.999999-07",
+	"2006-01-02 15:04:05.999999",
+	"2006-01-02",
+}
+
+func parseBound(s string) (time.Time, error) {
+	if s == "MINVALUE" || s == "MAXVALUE" {
+		return time.Time{}, nil
+	}
+	s = strings.Trim(s, "'")
+	for _, layout := range boundLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized partition bound %q", s)
+}
+
+// introspect loads the partition key, columns and existing partitions. It
+// runs with TimeZone = UTC so timestamptz bounds print as +00.
+func introspect(ctx context.Context, tx pgx.Tx, table string) (*tableInfo, error) {
+	if _, err := tx.Exec(ctx, "SET LOCAL TimeZone = 'UTC'"); err != nil {
+		return nil, err
+	}
+
+	info := &tableInfo{}
+	var strategy string
+	var keyCount int
+	err := tx.QueryRow(ctx, `
+		SELECT n.nspname, c.relname, pt.partstrat::text, pt.partnatts,
+			COALESCE(a.attname, ''), COALESCE(format_type(a.atttypid, a.atttypmod), '')
+		FROM pg_partitioned_table pt
+		JOIN pg_class c ON c.oid = pt.partrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attribute a ON a.attrelid = pt.partrelid AND a.attnum = pt.partattrs[0]
+		WHERE pt.partrelid = $1::regclass
+	`, table).Scan(&info.schema, &info.name, &strategy, &keyCount, &info.keyColumn, &info.keyType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%s is not a partitioned table", table)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("inspect %s: %w", table, err)
+	}
+	if strategy != "r" || keyCount != 1 || info.keyColumn == "" {
+		return nil, fmt.Errorf("%s must be range partitioned on a single column", table)
+	}
+	switch info.keyType {
+	case "date", "timestamp without time zone", "timestamp with time zone":
+	default:
+		return nil, fmt.Errorf("%s: partition key %s has unsupported type %s", table, info.keyColumn, info.keyType)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT attname FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped AND attgenerated = ''
+		ORDER BY attnum
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("list %s columns: %w", table, err)
+	}
+	if info.columns, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil {
+		return nil, fmt.Errorf("list %s columns: %w", table, err)
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT n.nspname, c.relname, pg_get_expr(c.relpartbound, c.oid)
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE i.inhparent = $1::regclass
+		ORDER BY c.relname
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("list %s partitions: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p partition
+		var bound string
+		if err := rows.Scan(&p.schema, &p.name, &bound); err != nil {
+			return nil, err
+		}
+		if bound == "DEFAULT" {
+			p.isDefault = true
+			info.defaultPart = &p
+			continue
+		}
+		m := boundRe.FindStringSubmatch(bound)
+		if m == nil {
+			return nil, fmt.Errorf("partition %s: unexpected bound %q", p.name, bound)
+		}
+		if p.from, err = parseBound(m[1]); err != nil {
+			return nil, fmt.Errorf("partition %s: %w", p.name, err)
+		}
+		if p.to, err = parseBound(m[2]); err != nil {
+			return nil, fmt.Errorf("partition %s: %w", p.name, err)
+		}
+		info.partitions = append(info.partitions, p)
+	}
+	return info, rows.Err()
+}
+
+// defaultRange returns the min and max key in the default partition, if any rows
+func defaultRange(ctx context.Context, tx pgx.Tx, info *tableInfo) (lo, hi *time.Time, err error) {
+	key := pgx.Identifier{info.keyColumn}.Sanitize()
+	err = tx.QueryRow(ctx, fmt.Sprintf(
+		"SELECT min(%s)::timestamptz, max(%s)::timestamptz FROM %s", key, key, info.defaultPart.ident().Sanitize(),
+	)).Scan(&lo, &hi)
+	if err != nil {
+		return nil, nil, fmt.Errorf("scan default partition: %w", err)
+	}
+	return lo, hi, nil
+}
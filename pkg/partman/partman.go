@@ -0,0 +1,326 @@
+package partman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/lock"
+)
+
+// maxBackfill caps how many past partitions are created for rows found in
+// the default partition, so one bad timestamp can't create thousands.
+const maxBackfill = 400
+
+// ActionKind is one kind of change the manager makes
+type ActionKind string
+
+const (
+	CreatePartition  ActionKind = "create"
+	CreateDefault    ActionKind = "create_default"
+	DropPartition    ActionKind = "drop"
+	DetachPartition  ActionKind = "detach"
+	ArchivePartition ActionKind = "archive"
+)
+
+// Action is a planned or applied change to one partition
+type Action struct {
+	Kind      ActionKind
+	Table     string // parent
+	Partition string
+	From, To  time.Time
+	RowsMoved int64 // rows moved out of the default partition on create
+}
+
+func (a Action) String() string {
+	switch a.Kind {
+	case CreatePartition:
+		s := fmt.Sprintf("create %s [%s, %s)", a.Partition, a.From.Format(time.DateOnly), a.To.Format(time.DateOnly))
+		if a.RowsMoved > 0 {
+			s += fmt.Sprintf(", moved %d rows from default", a.RowsMoved)
+		}
+		return s
+	case CreateDefault:
+		return "create default partition " + a.Partition
+	default:
+		return fmt.Sprintf("%s %s (ended %s)", a.Kind, a.Partition, a.To.Format(time.DateOnly))
+	}
+}
+
+// Manager keeps range-partitioned tables supplied with partitions
+type Manager struct {
+	pool   *database.Pool
+	locker *lock.Locker
+	Logger *slog.Logger
+	Now    func() time.Time // for tests and backfills; defaults to time.Now
+}
+
+// New creates a Manager
+func New(pool *database.Pool) *Manager {
+	return &Manager{pool: pool, locker: lock.New(pool, "partman"), Logger: slog.Default(), Now: time.Now}
+}
+
+// Plan works out what Maintain would do for tc without changing anything
+func (m *Manager) Plan(ctx context.Context, tc TableConfig) ([]Action, error) {
+	tc, err := tc.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	var actions []Action
+	err = database.WithTx(ctx, m.pool, func(tx pgx.Tx) error {
+		info, err := introspect(ctx, tx, tc.Table)
+		if err != nil {
+			return err
+		}
+		actions, err = m.plan(ctx, tx, tc, info)
+		return err
+	})
+	return actions, err
+}
+
+// Maintain brings tc's partitions up to date: it creates the current and
+// Premake future partitions (plus past ones that rows in the default
+// partition belong to), creates the default partition if configured, and
+// expires partitions that ended before now - Retention. Each change runs in
+// its own transaction, under an advisory lock so concurrent runs don't
+// collide. It returns the actions applied, even when it fails part way.
+func (m *Manager) Maintain(ctx context.Context, tc TableConfig) ([]Action, error) {
+	tc, err := tc.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := m.locker.TryLock(ctx, tc.Table)
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil {
+		m.Logger.Info("partman: another run holds the lock, skipping", "table", tc.Table)
+		return nil, nil
+	}
+	defer lease.Close()
+
+	planned, err := m.Plan(ctx, tc)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Action
+	for _, action := range planned {
+		action, err := m.apply(ctx, tc, action)
+		if err != nil {
+			return applied, fmt.Errorf("%s: %w", action, err)
+		}
+		m.Logger.Info("partman: "+action.String(), "table", tc.Table)
+		applied = append(applied, action)
+	}
+	return applied, nil
+}
+
+// MaintainAll maintains every table, carrying on past failures
+func (m *Manager) MaintainAll(ctx context.Context, tables []TableConfig) ([]Action, error) {
+	var all []Action
+	var errs []error
+	for _, tc := range tables {
+		actions, err := m.Maintain(ctx, tc)
+		all = append(all, actions...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tc.Table, err))
+		}
+	}
+	return all, errors.Join(errs...)
+}
+
+// Run maintains tables now and then every interval until ctx is cancelled
+func (m *Manager) Run(ctx context.Context, tables []TableConfig, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.MaintainAll(ctx, tables); err != nil && ctx.Err() == nil {
+			m.Logger.Warn("partman: maintenance failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) plan(ctx context.Context, tx pgx.Tx, tc TableConfig, info *tableInfo) ([]Action, error) {
+	now := m.Now().UTC()
+	var cutoff time.Time
+	if tc.Retention > 0 {
+		cutoff = now.Add(-tc.Retention)
+	}
+
+	// Periods we want partitions for: current + Premake ahead...
+	var periods []time.Time
+	start := tc.Interval.start(now)
+	for i := 0; i <= *tc.Premake; i++ {
+		periods = append(periods, start)
+		start = tc.Interval.next(start)
+	}
+
+	// ...plus any still-retained period that has rows sitting in the default
+	if info.defaultPart != nil {
+		lo, hi, err := defaultRange(ctx, tx, info)
+		if err != nil {
+			return nil, err
+		}
+		if lo != nil {
+			from := tc.Interval.start(*lo)
+			for i := 0; !from.After(*hi) && i < maxBackfill; i++ {
+				to := tc.Interval.next(from)
+				if cutoff.IsZero() || to.After(cutoff) {
+					periods = append(periods, from)
+				}
+				from = to
+			}
+		}
+	}
+
+	var actions []Action
+	planned := make(map[time.Time]bool)
+	for _, from := range periods {
+		to := tc.Interval.next(from)
+		if planned[from] || covered(info.partitions, from, to) {
+			continue
+		}
+		planned[from] = true
+		actions = append(actions, Action{
+			Kind:      CreatePartition,
+			Table:     tc.Table,
+			Partition: info.name + "_" + tc.Interval.suffix(from),
+			From:      from,
+			To:        to,
+		})
+	}
+
+	if tc.DefaultPartition && info.defaultPart == nil {
+		actions = append(actions, Action{Kind: CreateDefault, Table: tc.Table, Partition: info.name + "_default"})
+	}
+
+	if !cutoff.IsZero() {
+		kind := map[ExpirePolicy]ActionKind{Drop: DropPartition, Detach: DetachPartition, Archive: ArchivePartition}[tc.Expire]
+		for _, p := range info.partitions {
+			if !p.to.IsZero() && !p.to.After(cutoff) {
+				actions = append(actions, Action{Kind: kind, Table: tc.Table, Partition: p.name, From: p.from, To: p.to})
+			}
+		}
+	}
+	return actions, nil
+}
+
+// covered reports whether an existing partition already overlaps [from, to).
+// A partial overlap can't be fixed automatically, so it counts as covered.
+func covered(parts []partition, from, to time.Time) bool {
+	for _, p := range parts {
+		if p.overlaps(from, to) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) apply(ctx context.Context, tc TableConfig, action Action) (Action, error) {
+	err := database.WithTx(ctx, m.pool, func(tx pgx.Tx) error {
+		// Re-read inside the transaction; another tool may have changed things
+		info, err := introspect(ctx, tx, tc.Table)
+		if err != nil {
+			return err
+		}
+		part := pgx.Identifier{info.schema, action.Partition}
+
+		switch action.Kind {
+		case CreatePartition:
+			if covered(info.partitions, action.From, action.To) {
+				return nil
+			}
+			action.RowsMoved, err = createPartition(ctx, tx, info, part, action.From, action.To)
+			return err
+
+		case CreateDefault:
+			if info.defaultPart != nil {
+				return nil
+			}
+			_, err = tx.Exec(ctx, fmt.Sprintf("CREATE TABLE %s PARTITION OF %s DEFAULT", part.Sanitize(), info.ident().Sanitize()))
+			return err
+
+		default:
+			// Plain DETACH: CONCURRENTLY can't run in a transaction or
+			// alongside a default partition.
+			_, err = tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", info.ident().Sanitize(), part.Sanitize()))
+			if err != nil {
+				return err
+			}
+			switch action.Kind {
+			case DropPartition:
+				_, err = tx.Exec(ctx, "DROP TABLE "+part.Sanitize())
+			case ArchivePartition:
+				schema := pgx.Identifier{tc.ArchiveSchema}.Sanitize()
+				if _, err = tx.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+schema); err == nil {
+					_, err = tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %s SET SCHEMA %s", part.Sanitize(), schema))
+				}
+			}
+			return err
+		}
+	})
+	return action, err
+}
+
+// createPartition adds a partition for [from, to). With a default partition
+// present, a plain CREATE ... PARTITION OF fails if the default already holds
+// rows in that range, so the partition is built standalone, the rows are
+// moved into it, and then it is attached.
+func createPartition(ctx context.Context, tx pgx.Tx, info *tableInfo, part pgx.Identifier, from, to time.Time) (int64, error) {
+	parent := info.ident().Sanitize()
+	bounds := fmt.Sprintf("FROM ('%s') TO ('%s')", info.literal(from), info.literal(to))
+
+	if info.defaultPart == nil {
+		_, err := tx.Exec(ctx, fmt.Sprintf("CREATE TABLE %s PARTITION OF %s FOR VALUES %s", part.Sanitize(), parent, bounds))
+		return 0, err
+	}
+
+	// Moving a big backlog can take a while
+	if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+		return 0, err
+	}
+
+	_, err := tx.Exec(ctx, fmt.Sprintf(
+		"CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS INCLUDING GENERATED INCLUDING STORAGE)",
+		part.Sanitize(), parent))
+	if err != nil {
+		return 0, err
+	}
+
+	cols := make([]string, len(info.columns))
+	for i, c := range info.columns {
+		cols[i] = pgx.Identifier{c}.Sanitize()
+	}
+	colList := strings.Join(cols, ", ")
+	key := pgx.Identifier{info.keyColumn}.Sanitize()
+	tag, err := tx.Exec(ctx, fmt.Sprintf(`
+		WITH moved AS (
+			DELETE FROM %s WHERE %s >= $1::%s AND %s < $2::%s
+			RETURNING %s
+		)
+		INSERT INTO %s (%s) SELECT %s FROM moved
+	`, info.defaultPart.ident().Sanitize(), key, info.keyType, key, info.keyType, colList,
+		part.Sanitize(), colList, colList),
+		info.literal(from), info.literal(to))
+	if err != nil {
+		return 0, fmt.Errorf("move rows from default partition: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s FOR VALUES %s", parent, part.Sanitize(), bounds))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,244 @@
+package pgstat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// Order picks what "top" means
+type Order string
+
+const (
+	ByTotalTime Order = "total" // most time spent overall
+	ByMeanTime  Order = "mean"  // slowest per call
+	ByCalls     Order = "calls" // run most often
+	ByRows      Order = "rows"  // return or touch the most rows
+	ByHitRatio  Order = "hit"   // worst shared buffer hit ratio first
+	ByTemp      Order = "temp"  // most temp blocks, i.e. spilling to disk
+)
+
+// Orders lists every Order
+var Orders = []Order{ByTotalTime, ByMeanTime, ByCalls, ByRows, ByHitRatio, ByTemp}
+
+var orderBy = map[Order]string{
+	ByTotalTime: "total_exec_time DESC",
+	ByMeanTime:  "total_exec_time / NULLIF(calls, 0) DESC NULLS LAST",
+	ByCalls:     "calls DESC",
+	ByRows:      "rows DESC",
+	ByHitRatio:  "shared_blks_hit::float8 / NULLIF(shared_blks_hit + shared_blks_read, 0) ASC NULLS LAST",
+	ByTemp:      "temp_blks_read + temp_blks_written DESC",
+}
+
+// Statement is one normalized query's counters. Times are in milliseconds.
+// In a diff, every counter is the change between the two snapshots.
+type Statement struct {
+	QueryID     int64   `json:"queryid"`
+	Query       string  `json:"query"`
+	Calls       int64   `json:"calls"`
+	TotalTime   float64 `json:"total_time_ms"`
+	MeanTime    float64 `json:"mean_time_ms"`
+	Rows        int64   `json:"rows"`
+	SharedHit   int64   `json:"shared_blks_hit"`
+	SharedRead  int64   `json:"shared_blks_read"`
+	HitRatio    float64 `json:"hit_ratio"` // 1 when nothing was read
+	TempRead    int64   `json:"temp_blks_read"`
+	TempWritten int64   `json:"temp_blks_written"`
+}
+
+// Snapshot is a saved copy of pg_stat_statements
+type Snapshot struct {
+	ID         int64     `json:"id"`
+	Label      string    `json:"label"`
+	TakenAt    time.Time `json:"taken_at"`
+	Statements int64     `json:"statements"`
+}
+
+// counters are the stat_snapshot_statements columns that add up
+var counters = []string{
+	"calls", "total_exec_time", "rows", "shared_blks_hit", "shared_blks_read",
+	"temp_blks_read", "temp_blks_written",
+}
+
+// live is pg_stat_statements for the current database. With
+// pg_stat_statements.track = all, a query can show up both top-level and
+// nested, so rows are summed per query.
+const live = `
+	SELECT userid, queryid, max(query) AS query,
+		sum(calls)::bigint AS calls, sum(total_exec_time) AS total_exec_time, sum(rows)::bigint AS rows,
+		sum(shared_blks_hit)::bigint AS shared_blks_hit, sum(shared_blks_read)::bigint AS shared_blks_read,
+		sum(temp_blks_read)::bigint AS temp_blks_read, sum(temp_blks_written)::bigint AS temp_blks_written
+	FROM pg_stat_statements
+	WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+		AND queryid IS NOT NULL
+	GROUP BY userid, queryid`
+
+// Top returns the limit statements that rank highest by order
+func Top(ctx context.Context, pool *database.Pool, order Order, limit int) ([]Statement, error) {
+	return top(ctx, pool, live, nil, order, limit)
+}
+
+// Diff returns the top statements by what they did between snapshot from
+// and snapshot to. A to of 0 means the live view. Statements that didn't
+// run in between are left out. If the stats were reset in between, the
+// newer counters are all since the reset and are used as is. A reset of
+// single statements isn't recorded in stats_reset, so a statement whose
+// calls went down is taken as reset too.
+func Diff(ctx context.Context, pool *database.Pool, from, to int64, order Order, limit int) ([]Statement, error) {
+	if to != 0 && to < from {
+		return nil, fmt.Errorf("snapshot %d is older than %d; diff from the older one", to, from)
+	}
+	newer := "(" + live + ")"
+	newerReset := "(SELECT stats_reset FROM pg_stat_statements_info)"
+	args := []any{from}
+	if to != 0 {
+		newer = "(SELECT * FROM stat_snapshot_statements WHERE snapshot_id = $2)"
+		newerReset = "(SELECT stats_reset FROM stat_snapshots WHERE id = $2)"
+		args = append(args, to)
+	}
+	for _, id := range args {
+		if err := exists(ctx, pool, id.(int64)); err != nil {
+			return nil, err
+		}
+	}
+
+	var reset bool
+	err := pool.QueryRow(ctx,
+		"SELECT stats_reset IS DISTINCT FROM "+newerReset+" FROM stat_snapshots WHERE id = $1", args...,
+	).Scan(&reset)
+	if err != nil {
+		return nil, fmt.Errorf("compare stats resets: %w", err)
+	}
+
+	cols := make([]string, len(counters))
+	for i, c := range counters {
+		if reset {
+			cols[i] = fmt.Sprintf("n.%[1]s AS %[1]s", c)
+		} else {
+			cols[i] = fmt.Sprintf("CASE WHEN o.calls IS NULL OR n.calls < o.calls THEN n.%[1]s ELSE n.%[1]s - o.%[1]s END AS %[1]s", c)
+		}
+	}
+	source := fmt.Sprintf(`
+		SELECT n.userid, n.queryid, n.query, %s
+		FROM %s n
+		LEFT JOIN stat_snapshot_statements o
+			ON o.snapshot_id = $1 AND o.userid = n.userid AND o.queryid = n.queryid`,
+		strings.Join(cols, ", "), newer)
+	return top(ctx, pool, "SELECT * FROM ("+source+") d WHERE calls > 0", args, order, limit)
+}
+
+// top ranks the rows of source, which has the stat_snapshot_statements
+// columns
+func top(ctx context.Context, pool *database.Pool, source string, args []any, order Order, limit int) ([]Statement, error) {
+	by, ok := orderBy[order]
+	if !ok {
+		return nil, fmt.Errorf("unknown order %q", order)
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+	rows, err := pool.Query(ctx, fmt.Sprintf(`
+		SELECT queryid, query, calls, total_exec_time, rows,
+			shared_blks_hit, shared_blks_read, temp_blks_read, temp_blks_written
+		FROM (%s) s
+		ORDER BY %s, queryid
+		LIMIT %d
+	`, source, by, limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("query pg_stat_statements: %w", err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Statement, error) {
+		var s Statement
+		if err := row.Scan(&s.QueryID, &s.Query, &s.Calls, &s.TotalTime, &s.Rows,
+			&s.SharedHit, &s.SharedRead, &s.TempRead, &s.TempWritten); err != nil {
+			return s, err
+		}
+		if s.Calls > 0 {
+			s.MeanTime = s.TotalTime / float64(s.Calls)
+		}
+		s.HitRatio = 1
+		if s.SharedHit+s.SharedRead > 0 {
+			s.HitRatio = float64(s.SharedHit) / float64(s.SharedHit+s.SharedRead)
+		}
+		return s, nil
+	})
+}
+
+// Take saves the current database's pg_stat_statements as a new snapshot
+func Take(ctx context.Context, pool *database.Pool, label string) (Snapshot, error) {
+	snap := Snapshot{Label: label}
+	err := database.WithTx(ctx, pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx,
+			`INSERT INTO stat_snapshots (label, stats_reset)
+			VALUES ($1, (SELECT stats_reset FROM pg_stat_statements_info))
+			RETURNING id, taken_at`, label,
+		).Scan(&snap.ID, &snap.TakenAt); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, fmt.Sprintf(`
+			INSERT INTO stat_snapshot_statements (snapshot_id, userid, queryid, query, %s)
+			SELECT $1, userid, queryid, query, %s FROM (%s) s
+		`, strings.Join(counters, ", "), strings.Join(counters, ", "), live), snap.ID)
+		snap.Statements = tag.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("take snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// Snapshots lists saved snapshots, oldest first
+func Snapshots(ctx context.Context, pool *database.Pool) ([]Snapshot, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT s.id, s.label, s.taken_at, COUNT(st.queryid)
+		FROM stat_snapshots s
+		LEFT JOIN stat_snapshot_statements st ON st.snapshot_id = s.id
+		GROUP BY s.id
+		ORDER BY s.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Snapshot, error) {
+		var s Snapshot
+		err := row.Scan(&s.ID, &s.Label, &s.TakenAt, &s.Statements)
+		return s, err
+	})
+}
+
+// Drop deletes a snapshot
+func Drop(ctx context.Context, pool *database.Pool, id int64) error {
+	tag, err := pool.Exec(ctx, "DELETE FROM stat_snapshots WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("drop snapshot %d: %w", id, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("snapshot %d not found", id)
+	}
+	return nil
+}
+
+// Reset clears pg_stat_statements for every database, so the next report
+// starts from zero. Snapshots are kept.
+func Reset(ctx context.Context, pool *database.Pool) error {
+	if _, err := pool.Exec(ctx, "SELECT pg_stat_statements_reset()"); err != nil {
+		return fmt.Errorf("reset pg_stat_statements: %w", err)
+	}
+	return nil
+}
+
+func exists(ctx context.Context, pool *database.Pool, id int64) error {
+	var found bool
+	if err := pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM stat_snapshots WHERE id = $1)", id).Scan(&found); err != nil {
+		return fmt.Errorf("look up snapshot %d: %w", id, err)
+	}
+	if !found {
+		return fmt.Errorf("snapshot %d not found", id)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package pgstat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// testPool connects to PLAYGROUND_TEST_DATABASE_URL, a migrated database
+// with pg_stat_statements loaded, skipping the test when it isn't set
+func testPool(t *testing.T) *database.Pool {
+	t.Helper()
+	dsn := os.Getenv("PLAYGROUND_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("set PLAYGROUND_TEST_DATABASE_URL to run against a live server")
+	}
+	t.Setenv(database.ConfigFileEnv, "")
+	t.Setenv("DATABASE_URL", dsn)
+	cfg, err := database.LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := database.NewPool(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// fakeSnapshot saves a snapshot taken after a stats reset at statsReset,
+// holding the given calls per queryid, with every other counter derived
+// from calls
+func fakeSnapshot(t *testing.T, pool *database.Pool, statsReset time.Time, calls map[int64]int64) int64 {
+	t.Helper()
+	ctx := context.Background()
+	var id int64
+	err := pool.QueryRow(ctx,
+		"INSERT INTO stat_snapshots (label, stats_reset) VALUES ('pgstat test', $1) RETURNING id", statsReset,
+	).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Drop(context.Background(), pool, id) })
+
+	for queryID, n := range calls {
+		_, err := pool.Exec(ctx, `
+			INSERT INTO stat_snapshot_statements
+				(snapshot_id, userid, queryid, query, calls, total_exec_time, rows,
+				 shared_blks_hit, shared_blks_read, temp_blks_read, temp_blks_written)
+			VALUES ($1, 10, $2, $3, $4, $4 * 2.0, $4 * 10, $4 * 3, $4, 0, 0)
+		`, id, queryID, fmt.Sprintf("SELECT %d", queryID), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	base := -time.Now().UnixNano() // unlikely to clash with real queryids
+	grew, reset, idle, added := base, base-1, base-2, base-3
+
+	statsReset := time.Now().Add(-time.Hour)
+	from := fakeSnapshot(t, pool, statsReset, map[int64]int64{grew: 10, reset: 500, idle: 7})
+	to := fakeSnapshot(t, pool, statsReset, map[int64]int64{grew: 25, reset: 4, idle: 7, added: 3})
+
+	stmts, err := Diff(ctx, pool, from, to, ByCalls, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[int64]Statement{}
+	for _, s := range stmts {
+		got[s.QueryID] = s
+	}
+	if len(got) != 3 {
+		t.Errorf("got %d statements, want 3: %+v", len(got), stmts)
+	}
+
+	want := map[int64]int64{
+		grew:  15, // 25 - 10
+		reset: 4,  // counters went backwards: the statement was reset, so use them as is
+		added: 3,  // new since the first snapshot
+	}
+	for queryID, calls := range want {
+		s, ok := got[queryID]
+		if !ok {
+			t.Errorf("queryid %d missing from diff", queryID)
+			continue
+		}
+		if s.Calls != calls || s.TotalTime != float64(calls)*2 || s.Rows != calls*10 {
+			t.Errorf("queryid %d: calls %d time %v rows %d, want %d/%v/%d",
+				queryID, s.Calls, s.TotalTime, s.Rows, calls, float64(calls)*2, calls*10)
+		}
+		if s.MeanTime != 2 || s.HitRatio != 0.75 {
+			t.Errorf("queryid %d: mean %v hit ratio %v, want 2 and 0.75", queryID, s.MeanTime, s.HitRatio)
+		}
+	}
+	if _, ok := got[idle]; ok {
+		t.Error("a statement that didn't run in between shows up in the diff")
+	}
+	if len(stmts) == 3 && stmts[0].QueryID != grew {
+		t.Errorf("ordered by calls, %d should come first, got %d", grew, stmts[0].QueryID)
+	}
+
+	if _, err := Diff(ctx, pool, to+1000000, 0, ByCalls, 10); err == nil {
+		t.Error("diff from a missing snapshot should fail")
+	}
+	if _, err := Diff(ctx, pool, to, from, ByCalls, 10); err == nil {
+		t.Error("diff from a newer to an older snapshot should fail")
+	}
+}
+
+func TestDiffAcrossReset(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	queryID := -time.Now().UnixNano()
+	before := time.Now().Add(-time.Hour)
+	from := fakeSnapshot(t, pool, before, map[int64]int64{queryID: 10})
+	// Reset in between, then more calls than before: calls alone can't tell
+	to := fakeSnapshot(t, pool, before.Add(time.Minute), map[int64]int64{queryID: 25})
+
+	stmts, err := Diff(ctx, pool, from, to, ByCalls, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stmts {
+		if s.QueryID == queryID {
+			if s.Calls != 25 || s.Rows != 250 {
+				t.Errorf("calls %d rows %d, want the post-reset 25 and 250", s.Calls, s.Rows)
+			}
+			return
+		}
+	}
+	t.Errorf("queryid %d missing from diff", queryID)
+}
+
+func TestDiffAgainstLive(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	snap, err := Take(ctx, pool, "pgstat live test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Drop(ctx, pool, snap.ID)
+
+	// A query text nothing else runs, so its queryid is ours alone
+	marker := fmt.Sprintf("pgstat_live_%d", time.Now().UnixNano())
+	query := fmt.Sprintf("SELECT $1::int AS %s", marker)
+	for i := 0; i < 5; i++ {
+		if _, err := pool.Exec(ctx, query, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stmts, err := Diff(ctx, pool, snap.ID, 0, ByCalls, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stmts {
+		if s.Query == query {
+			if s.Calls != 5 {
+				t.Errorf("calls = %d since the snapshot, want 5", s.Calls)
+			}
+			return
+		}
+	}
+	t.Errorf("%q not in the diff against the live view", query)
+}
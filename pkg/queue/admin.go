@@ -0,0 +1,126 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+)
+
+// QueueStats counts a queue's jobs by state
+type QueueStats struct {
+	Queue     string
+	Pending   int64
+	Scheduled int64 // pending with run_at in the future
+	Running   int64
+	Done      int64
+	Dead      int64
+	OldestDue *time.Time // run_at of the longest-waiting due job
+}
+
+// Stats summarizes every queue
+func Stats(ctx context.Context, pool *database.Pool) ([]QueueStats, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT
+			queue,
+			count(*) FILTER (WHERE state = 'pending' AND run_at <= NOW()),
+			count(*) FILTER (WHERE state = 'pending' AND run_at > NOW()),
+			count(*) FILTER (WHERE state = 'running'),
+			count(*) FILTER (WHERE state = 'done'),
+			count(*) FILTER (WHERE state = 'dead'),
+			min(run_at) FILTER (WHERE state = 'pending' AND run_at <= NOW())
+		FROM jobs
+		GROUP BY queue
+		ORDER BY queue
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("queue stats: %w", err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (QueueStats, error) {
+		var s QueueStats
+		err := row.Scan(&s.Queue, &s.Pending, &s.Scheduled, &s.Running, &s.Done, &s.Dead, &s.OldestDue)
+		return s, err
+	})
+}
+
+// Filter selects jobs for List and Requeue; zero fields match everything
+type Filter struct {
+	Queue string
+	Kind  string
+	State State
+	Limit int // List only; default 50
+}
+
+// List returns matching jobs, newest first
+func List(ctx context.Context, pool *database.Pool, f Filter) ([]Job, error) {
+	if f.Limit <= 0 {
+		f.Limit = 50
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE ($1 = '' OR queue = $1)
+			AND ($2 = '' OR kind = $2)
+			AND ($3 = '' OR state = $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`, f.Queue, f.Kind, string(f.State), f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	return pgx.CollectRows(rows, scanJob)
+}
+
+// Get returns one job
+func Get(ctx context.Context, pool *database.Pool, id int64) (Job, error) {
+	rows, err := pool.Query(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = $1", id)
+	if err != nil {
+		return Job{}, fmt.Errorf("get job %d: %w", id, err)
+	}
+	job, err := pgx.CollectExactlyOneRow(rows, scanJob)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, fmt.Errorf("job %d not found", id)
+	}
+	return job, err
+}
+
+// Requeue makes jobs runnable now with a fresh set of attempts. Pass ids,
+// or nil to requeue everything matching f; running jobs are never touched.
+// It returns how many jobs were requeued.
+func Requeue(ctx context.Context, pool *database.Pool, ids []int64, f Filter) (int64, error) {
+	if ids == nil && f.State == "" {
+		// Requeueing done jobs by accident would re-run all of history
+		f.State = Dead
+	}
+	tag, err := pool.Exec(ctx, `
+		UPDATE jobs
+		SET state = 'pending', attempts = 0, run_at = NOW(),
+			locked_by = NULL, locked_until = NULL, finished_at = NULL
+		WHERE state <> 'running'
+			AND ($1::bigint[] IS NULL OR id = ANY($1))
+			AND ($2 = '' OR queue = $2)
+			AND ($3 = '' OR kind = $3)
+			AND ($4 = '' OR state = $4)
+	`, ids, f.Queue, f.Kind, string(f.State))
+	if err != nil {
+		return 0, fmt.Errorf("requeue jobs: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Purge deletes done jobs (and dead ones if includeDead) finished before
+// olderThan ago, returning how many were removed.
+func Purge(ctx context.Context, pool *database.Pool, olderThan time.Duration, includeDead bool) (int64, error) {
+	tag, err := pool.Exec(ctx, `
+		DELETE FROM jobs
+		WHERE (state = 'done' OR ($2 AND state = 'dead'))
+			AND finished_at < NOW() - make_interval(secs => $1)
+	`, olderThan.Seconds(), includeDead)
+	if err != nil {
+		return 0, fmt.Errorf("purge jobs: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
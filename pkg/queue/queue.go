@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultQueue is used when EnqueueOptions.Queue is empty
+const DefaultQueue = "default"
+
+// State is a job's lifecycle state
+type State string
+
+const (
+	Pending State = "pending"
+	Running State = "running"
+	Done    State = "done"
+	Dead    State = "dead" // out of attempts; requeue by hand
+)
+
+// Args is a job's typed payload. Kind routes it to a handler, so it must be
+// stable across deploys.
+type Args interface {
+	Kind() string
+}
+
+// Job is a row from the jobs table
+type Job struct {
+	ID          int64
+	Queue       string
+	Kind        string
+	Payload     json.RawMessage
+	Priority    int16
+	State       State
+	Attempts    int // including the current one while running
+	MaxAttempts int
+	RunAt       time.Time
+	LockedBy    *string
+	LockedUntil *time.Time
+	LastError   *string
+	CreatedAt   time.Time
+	FinishedAt  *time.Time
+}
+
+const jobColumns = `id, queue, kind, payload, priority, state, attempts, max_attempts,
+	run_at, locked_by, locked_until, last_error, created_at, finished_at`
+
+func scanJob(row pgx.CollectableRow) (Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.Queue, &j.Kind, &j.Payload, &j.Priority, &j.State, &j.Attempts, &j.MaxAttempts,
+		&j.RunAt, &j.LockedBy, &j.LockedUntil, &j.LastError, &j.CreatedAt, &j.FinishedAt)
+	return j, err
+}
+
+// EnqueueOptions are per-job settings; zero values mean the defaults
+type EnqueueOptions struct {
+	Queue       string
+	Priority    int16     // higher runs first
+	RunAt       time.Time // don't run before this
+	MaxAttempts int       // default 5
+}
+
+// Querier is satisfied by *database.Pool and pgx.Tx, so jobs can be
+// enqueued in the same transaction as the change that needs them.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Enqueue inserts a job for args and returns its ID
+func Enqueue(ctx context.Context, db Querier, args Args, opts EnqueueOptions) (int64, error) {
+	if args.Kind() == "" {
+		return 0, errors.New("queue: job kind is required")
+	}
+	payload, err := json.Marshal(args)
+	if err != nil {
+		return 0, fmt.Errorf("marshal %s args: %w", args.Kind(), err)
+	}
+	if opts.Queue == "" {
+		opts.Queue = DefaultQueue
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	var runAt *time.Time
+	if !opts.RunAt.IsZero() {
+		runAt = &opts.RunAt
+	}
+
+	var id int64
+	err = db.QueryRow(ctx, `
+		INSERT INTO jobs (queue, kind, payload, priority, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, NOW()))
+		RETURNING id
+	`, opts.Queue, args.Kind(), payload, opts.Priority, opts.MaxAttempts, runAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("enqueue %s: %w", args.Kind(), err)
+	}
+	return id, nil
+}
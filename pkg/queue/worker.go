@@ -0,0 +1,362 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"roguh.com/postgres_playground/pkg/database"
+	"roguh.com/postgres_playground/pkg/notify"
+)
+
+// notifyChannel is where the jobs_notify_ready trigger announces work
+const notifyChannel = "jobs"
+
+// Handler runs one job. Returning an error schedules a retry, or moves the
+// job to Dead once MaxAttempts is used up.
+type Handler func(ctx context.Context, job *Job) error
+
+// WorkerConfig tunes a Worker
+type WorkerConfig struct {
+	Queue             string
+	ID                string        // written to locked_by (default hostname-pid)
+	Concurrency       int           // jobs run at once
+	VisibilityTimeout time.Duration // a job not finished or extended by then is run again
+	PollInterval      time.Duration // fallback when NOTIFY is unavailable or missed
+	RetryDelay        time.Duration // backoff after the first failure
+	MaxDelay          time.Duration // cap on backoff
+	Logger            *slog.Logger
+}
+
+// DefaultWorkerConfig returns sensible defaults
+func DefaultWorkerConfig() WorkerConfig {
+	host, _ := os.Hostname()
+	return WorkerConfig{
+		Queue:             DefaultQueue,
+		ID:                fmt.Sprintf("%s-%d", host, os.Getpid()),
+		Concurrency:       4,
+		VisibilityTimeout: 5 * time.Minute,
+		PollInterval:      10 * time.Second,
+		RetryDelay:        10 * time.Second,
+		MaxDelay:          time.Hour,
+	}
+}
+
+// Worker claims jobs from one queue and runs them through registered handlers
+type Worker struct {
+	pool     *database.Pool
+	cfg      WorkerConfig
+	handlers map[string]Handler
+}
+
+// NewWorker creates a worker; register handlers before calling Run
+func NewWorker(pool *database.Pool, cfg WorkerConfig) *Worker {
+	defaults := DefaultWorkerConfig()
+	if cfg.Queue == "" {
+		cfg.Queue = defaults.Queue
+	}
+	if cfg.ID == "" {
+		cfg.ID = defaults.ID
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaults.Concurrency
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		cfg.VisibilityTimeout = defaults.VisibilityTimeout
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaults.PollInterval
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaults.RetryDelay
+	}
+	if cfg.MaxDelay < cfg.RetryDelay {
+		cfg.MaxDelay = max(defaults.MaxDelay, cfg.RetryDelay)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	cfg.Logger = cfg.Logger.With("queue", cfg.Queue, "worker", cfg.ID)
+	return &Worker{pool: pool, cfg: cfg, handlers: make(map[string]Handler)}
+}
+
+// Handle registers h for jobs of kind
+func (w *Worker) Handle(kind string, h Handler) {
+	w.handlers[kind] = h
+}
+
+// Register registers a typed handler; the payload is decoded into T
+func Register[T Args](w *Worker, fn func(ctx context.Context, job *Job, args T) error) {
+	var zero T
+	w.Handle(zero.Kind(), func(ctx context.Context, job *Job) error {
+		var args T
+		if err := json.Unmarshal(job.Payload, &args); err != nil {
+			return fmt.Errorf("decode %s args: %w", job.Kind, err)
+		}
+		return fn(ctx, job, args)
+	})
+}
+
+// Run claims and runs jobs until ctx is cancelled, then waits for running
+// jobs to return. Jobs interrupted by shutdown go back to pending without
+// using up an attempt.
+func (w *Worker) Run(ctx context.Context) error {
+	wake := make(chan struct{}, 1)
+	signal := func() {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+
+	w.listen(ctx, signal)
+
+	slots := make(chan struct{}, w.cfg.Concurrency)
+	var running sync.WaitGroup
+	defer running.Wait()
+
+	for {
+		if err := w.reap(ctx); err != nil && ctx.Err() == nil {
+			w.cfg.Logger.Warn("reap expired jobs", "error", err)
+		}
+
+		free := w.cfg.Concurrency - len(slots)
+		var jobs []Job
+		if free > 0 {
+			var err error
+			jobs, err = w.claim(ctx, free)
+			if err != nil && ctx.Err() == nil {
+				w.cfg.Logger.Warn("claim jobs", "error", err)
+			}
+		}
+		for i := range jobs {
+			slots <- struct{}{}
+			running.Add(1)
+			go func(job *Job) {
+				defer running.Done()
+				defer func() { <-slots; signal() }()
+				w.run(ctx, job)
+			}(&jobs[i])
+		}
+		if len(jobs) == free && free > 0 {
+			// Probably more waiting; go straight back for them
+			continue
+		}
+
+		if free == 0 {
+			// Every slot is busy; a finishing job signals wake
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-wake:
+			}
+			continue
+		}
+
+		timer := time.NewTimer(w.idleWait(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// listen subscribes to job notifications, falling back to polling if that
+// isn't possible (e.g. behind PgBouncer).
+func (w *Worker) listen(ctx context.Context, signal func()) {
+	cfg := w.pool.Config()
+	if cfg == nil {
+		return
+	}
+	listener, err := notify.NewListener(cfg, notify.Options{Logger: w.cfg.Logger, SlowConsumer: notify.DropNewest})
+	if err != nil {
+		w.cfg.Logger.Warn("job notifications unavailable, polling only", "error", err)
+		return
+	}
+	go listener.Run(ctx)
+
+	go func() {
+		sub, err := listener.Subscribe(ctx, notifyChannel)
+		if err != nil {
+			return
+		}
+		for ev := range sub.C {
+			// Missed events count too: the queue may have filled meanwhile
+			if ev.Missed || ev.Payload == w.cfg.Queue {
+				signal()
+			}
+		}
+	}()
+}
+
+// idleWait sleeps until the next scheduled job, but no longer than
+// PollInterval. A job already due was left unclaimed because another worker
+// holds it, so that waits the full PollInterval rather than spinning.
+func (w *Worker) idleWait(ctx context.Context) time.Duration {
+	var seconds *float64
+	err := w.pool.QueryRow(ctx, `
+		SELECT EXTRACT(EPOCH FROM min(run_at) - NOW())::float8
+		FROM jobs
+		WHERE queue = $1 AND state = 'pending'
+	`, w.cfg.Queue).Scan(&seconds)
+	if err != nil || seconds == nil {
+		return w.cfg.PollInterval
+	}
+	until := time.Duration(*seconds * float64(time.Second))
+	if until <= 0 {
+		return w.cfg.PollInterval
+	}
+	return min(max(until, 10*time.Millisecond), w.cfg.PollInterval)
+}
+
+// reap returns running jobs whose visibility timeout passed (their worker
+// died or hung) to pending, or to dead if they were on their last attempt.
+func (w *Worker) reap(ctx context.Context) error {
+	_, err := w.pool.Exec(ctx, `
+		UPDATE jobs
+		SET state = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+			finished_at = CASE WHEN attempts >= max_attempts THEN NOW() END,
+			last_error = 'visibility timeout expired (worker ' || COALESCE(locked_by, '?') || ')',
+			locked_by = NULL,
+			locked_until = NULL
+		WHERE queue = $1 AND state = 'running' AND locked_until < NOW()
+	`, w.cfg.Queue)
+	return err
+}
+
+// claim locks up to limit due jobs and marks them running for this worker
+func (w *Worker) claim(ctx context.Context, limit int) ([]Job, error) {
+	rows, err := w.pool.Query(ctx, `
+		UPDATE jobs
+		SET state = 'running',
+			attempts = attempts + 1,
+			locked_by = $3,
+			locked_until = NOW() + make_interval(secs => $4)
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE queue = $1 AND state = 'pending' AND run_at <= NOW()
+			ORDER BY priority DESC, run_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		w.cfg.Queue, limit, w.cfg.ID, w.cfg.VisibilityTimeout.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanJob)
+}
+
+func (w *Worker) run(ctx context.Context, job *Job) {
+	log := w.cfg.Logger.With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+
+	// Bookkeeping must land even while shutting down
+	bg := context.WithoutCancel(ctx)
+
+	handler, ok := w.handlers[job.Kind]
+	if !ok {
+		w.fail(bg, log, job, fmt.Errorf("no handler registered for kind %q", job.Kind))
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	extendDone := make(chan struct{})
+	go func() {
+		defer close(extendDone)
+		w.extend(jobCtx, log, job)
+	}()
+
+	start := time.Now()
+	err := runHandler(jobCtx, handler, job)
+	cancel()
+	<-extendDone
+
+	switch {
+	case err == nil:
+		w.finish(bg, log, job, `state = 'done', finished_at = NOW(), last_error = NULL`)
+		log.Debug("job done", "duration", time.Since(start))
+	case ctx.Err() != nil:
+		// Shutdown, not the job's fault: hand it back untouched
+		w.finish(bg, log, job, `state = 'pending', attempts = attempts - 1`)
+	default:
+		w.fail(bg, log, job, err)
+	}
+}
+
+func runHandler(ctx context.Context, h Handler, job *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// extend pushes locked_until forward while the handler runs, so only jobs
+// whose worker is gone hit the visibility timeout.
+func (w *Worker) extend(ctx context.Context, log *slog.Logger, job *Job) {
+	ticker := time.NewTicker(w.cfg.VisibilityTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, err := w.pool.Exec(ctx, `
+			UPDATE jobs SET locked_until = NOW() + make_interval(secs => $4)
+			WHERE id = $1 AND locked_by = $2 AND attempts = $3 AND state = 'running'
+		`, job.ID, w.cfg.ID, job.Attempts, w.cfg.VisibilityTimeout.Seconds())
+		if err != nil && ctx.Err() == nil {
+			log.Warn("extend job visibility", "error", err)
+		}
+	}
+}
+
+func (w *Worker) fail(ctx context.Context, log *slog.Logger, job *Job, jobErr error) {
+	if job.Attempts >= job.MaxAttempts {
+		log.Error("job failed, moving to dead", "error", jobErr)
+		w.finish(ctx, log, job, `state = 'dead', finished_at = NOW(), last_error = $4`, jobErr.Error())
+		return
+	}
+	delay := w.backoff(job.Attempts)
+	log.Warn("job failed, retrying", "error", jobErr, "retry_in", delay)
+	w.finish(ctx, log, job, `state = 'pending', last_error = $4, run_at = NOW() + make_interval(secs => $5)`,
+		jobErr.Error(), delay.Seconds())
+}
+
+// finish applies set to a job this worker still owns. A job reclaimed after
+// its visibility timeout belongs to someone else now, so it is left alone.
+func (w *Worker) finish(ctx context.Context, log *slog.Logger, job *Job, set string, args ...any) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tag, err := w.pool.Exec(ctx, `
+		UPDATE jobs SET `+set+`, locked_by = NULL, locked_until = NULL
+		WHERE id = $1 AND locked_by = $2 AND attempts = $3 AND state = 'running'
+	`, append([]any{job.ID, w.cfg.ID, job.Attempts}, args...)...)
+	switch {
+	case err != nil:
+		log.Error("update job state", "error", err)
+	case tag.RowsAffected() == 0:
+		log.Warn("job was reclaimed after its visibility timeout; result discarded")
+	}
+}
+
+// backoff doubles RetryDelay per attempt up to MaxDelay
+func (w *Worker) backoff(attempt int) time.Duration {
+	delay := w.cfg.RetryDelay
+	for i := 1; i < attempt && delay < w.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, w.cfg.MaxDelay)
+}
@@ -0,0 +1,27 @@
+#!/bin/sh
+# Generate a throwaway CA plus server and client certificates for local TLS testing.
+# Usage: scripts/gen-certs.sh [output-dir]   (default: ./certs)
+set -eu
+
+OUT=${1:-certs}
+mkdir -p "$OUT"
+cd "$OUT"
+
+# Certificate authority
+openssl req -x509 -newkey rsa:2048 -nodes -days 365 \
+    -keyout ca.key -out ca.crt -subj "/CN=postgres-playground-ca"
+
+# Server certificate for localhost
+openssl req -newkey rsa:2048 -nodes -keyout server.key -out server.csr -subj "/CN=localhost"
+printf "subjectAltName=DNS:localhost,IP:127.0.0.1\n" > server.ext
+openssl x509 -req -in server.csr -CA ca.crt -CAkey ca.key -CAcreateserial \
+    -days 365 -out server.crt -extfile server.ext
+
+# Client certificate; CN must match the database user for cert auth
+openssl req -newkey rsa:2048 -nodes -keyout client.key -out client.csr -subj "/CN=grug"
+openssl x509 -req -in client.csr -CA ca.crt -CAkey ca.key -CAcreateserial \
+    -days 365 -out client.crt
+
+rm -f server.csr server.ext client.csr ca.srl
+chmod 600 ca.key server.key client.key
+echo "Certificates written to $OUT"